@@ -0,0 +1,200 @@
+package cmd
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	. "grapnel/flag"
+	. "grapnel/lib"
+	log "grapnel/log"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var (
+	addUrl    string
+	addBranch string
+	addTag    string
+	addType   string
+)
+
+// composes a package file entry out of only what was specified
+func packageEntry(importPath, version string) string {
+	entry := &bytes.Buffer{}
+	fmt.Fprintf(entry, "\n[[dependencies]]\n")
+	fmt.Fprintf(entry, "import = \"%s\"\n", importPath)
+	for _, item := range [][]string{
+		{"version", version},
+		{"type", addType},
+		{"url", addUrl},
+		{"branch", addBranch},
+		{"tag", addTag},
+	} {
+		if item[1] != "" {
+			fmt.Fprintf(entry, "%s = \"%s\"\n", item[0], item[1])
+		}
+	}
+	return entry.String()
+}
+
+// appends an entry to the package file, leaving the rest of the file as-is
+func appendPackageEntry(entry string) error {
+	data, err := ioutil.ReadFile(packageFileName)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(packageFileName, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		entry = "\n" + entry
+	}
+	_, err = file.WriteString(entry)
+	return err
+}
+
+func addFn(cmd *Command, args []string) error {
+	configureLogging()
+
+	if len(args) == 0 {
+		return fmt.Errorf("Missing import for 'add'")
+	} else if len(args) > 1 {
+		return fmt.Errorf("Too many arguments for 'add'")
+	}
+	setPackageDefaults()
+
+	// split the import from the version
+	importPath := args[0]
+	version := ""
+	if idx := strings.Index(importPath, "@"); idx >= 0 {
+		version = importPath[idx+1:]
+		importPath = importPath[:idx]
+	}
+
+	dep, err := NewDependency(importPath, addUrl, version)
+	if err != nil {
+		return err
+	}
+	dep.Type = addType
+	dep.Branch = addBranch
+	dep.Tag = addTag
+
+	deplist, err := loadPackageFile()
+	if err != nil {
+		return err
+	}
+	for _, item := range deplist {
+		if item.Import == dep.Import {
+			return fmt.Errorf("'%s' is already in the package file", dep.Import)
+		}
+	}
+
+	libs := []*Library{}
+	// resolve everything before we touch any files
+	resolver, err := getResolver()
+	if err != nil {
+		return err
+	}
+	deplist = append(deplist, dep)
+	metadata, err := newLockMetadata(deplist)
+	if err != nil {
+		return err
+	}
+	libs, err = resolver.ResolveDependencies(deplist)
+	if err != nil {
+		return err
+	}
+
+	if err := installLibraries(resolver, libs); err != nil {
+		return err
+	}
+	if err := writeLockFile(metadata, libs); err != nil {
+		return err
+	}
+
+	// the package file goes last, so it only changes once everything else has
+	log.Info("Adding %s to %s", dep.Import, packageFileName)
+	if err := appendPackageEntry(packageEntry(dep.Import, version)); err != nil {
+		return err
+	}
+
+	log.Info("Add complete")
+	return nil
+}
+
+var addCmd = Command{
+	Desc:    "Adds a dependency to the package file and installs it.",
+	ArgDesc: "[import][@version]",
+	Help: " Resolves the new dependency along with the rest of the package file,\n" +
+		" installs it, updates the lock file, and then appends it to the package\n" +
+		" file.  The package file is left alone if any of that fails.\n" +
+		"\nDefaults:\n" +
+		"  Package file = " + defaultPackageFileName + "\n" +
+		"  Lock file = " + defaultLockFileName + "\n" +
+		"  Target path = " + defaultTargetPath + "\n",
+	Flags: FlagMap{
+		"pconfig": &Flag{
+			Alias:   "p",
+			Desc:    "Grapnel package file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&packageFileName),
+		},
+		"lockfile": &Flag{
+			Alias:   "l",
+			Desc:    "Where to write the grapnel lock file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&lockFileName),
+		},
+		"target": &Flag{
+			Alias:   "t",
+			Desc:    "Target installation path",
+			ArgDesc: "[target]",
+			Fn:      StringFlagFn(&targetPath),
+		},
+		"url": &Flag{
+			Desc:    "Url for the dependency",
+			ArgDesc: "[url]",
+			Fn:      StringFlagFn(&addUrl),
+		},
+		"branch": &Flag{
+			Desc:    "Branch for the dependency",
+			ArgDesc: "[branch]",
+			Fn:      StringFlagFn(&addBranch),
+		},
+		"tag": &Flag{
+			Desc:    "Tag or commit for the dependency",
+			ArgDesc: "[tag]",
+			Fn:      StringFlagFn(&addTag),
+		},
+		"type": &Flag{
+			Desc:    "Repository type for the dependency",
+			ArgDesc: "[type]",
+			Fn:      StringFlagFn(&addType),
+		},
+	},
+	Fn: addFn,
+}
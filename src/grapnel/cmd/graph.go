@@ -0,0 +1,96 @@
+package cmd
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	. "grapnel/flag"
+	"os"
+)
+
+var graphFormat = "tree"
+
+func graphFn(cmd *Command, args []string) error {
+	configureLogging()
+
+	if len(args) > 0 {
+		return fmt.Errorf("Too many arguments for 'graph'")
+	}
+	switch graphFormat {
+	case "dot", "json", "tree":
+	default:
+		return fmt.Errorf("Unknown graph format: '%s'", graphFormat)
+	}
+	setPackageDefaults()
+
+	deplist, err := loadPackageFile()
+	if err != nil {
+		return err
+	}
+	resolver, err := getResolver()
+	if err != nil {
+		return err
+	}
+	graph, err := resolver.ResolveGraph(deplist)
+	if err != nil {
+		return err
+	}
+	switch graphFormat {
+	case "dot":
+		graph.ToDot(os.Stdout)
+	case "json":
+		return graph.ToJson(os.Stdout)
+	case "tree":
+		graph.ToTree(os.Stdout)
+	}
+	return nil
+}
+
+var graphCmd = Command{
+	Desc: "Shows the resolved dependency graph.",
+	Help: " Resolves the package file and writes the graph of dependencies to\n" +
+		" stdout.  Each library is shown with its version or tag, and the type of\n" +
+		" source it came from.  Nothing is installed or written.\n" +
+		"\nFormats:\n" +
+		"  tree = indented text, starting at the package file\n" +
+		"  dot = Graphviz DOT\n" +
+		"  json = libraries and their dependencies as JSON\n" +
+		"\nDefaults:\n" +
+		"  Package file = " + defaultPackageFileName + "\n" +
+		"  Format = tree\n",
+	Flags: FlagMap{
+		"pconfig": &Flag{
+			Alias:   "p",
+			Desc:    "Grapnel package file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&packageFileName),
+		},
+		"format": &Flag{
+			Alias:   "f",
+			Desc:    "Output format: tree, dot or json",
+			ArgDesc: "[format]",
+			Fn:      StringFlagFn(&graphFormat),
+		},
+	},
+	Fn: graphFn,
+}
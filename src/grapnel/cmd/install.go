@@ -28,11 +28,60 @@ import (
 	. "grapnel/flag"
 	log "grapnel/log"
 	"os"
+	"path"
 )
 
 // TODO: if no lock file can be found, then fail over to update instead
 // TODO: move lock file writing to updateFn()
 
+var (
+	flagLocked bool
+)
+
+// Compares the lock file against the package file and rewrite rules it was
+// resolved from.  The package file is the one next to the lock file, which is
+// where 'grapnel update' writes the lock file from.  Without a package file
+// there is nothing to compare against, which is only an error with --locked.
+func checkLockMetadata() error {
+	metadata, err := LoadLockMetadata(lockFileName)
+	if err != nil {
+		return err
+	}
+	if packageFileName == "" {
+		packageFileName = path.Join(path.Dir(lockFileName), path.Base(defaultPackageFileName))
+	}
+	deplist, err := LoadGrapnelDepsfile(packageFileName)
+	if err != nil {
+		return err
+	} else if deplist == nil {
+		if flagLocked {
+			return &exitError{fmt.Sprintf("Cannot check '%s' without a package file: '%s'",
+				lockFileName, packageFileName)}
+		}
+		log.Debug("No package file to check the lock file against")
+		return nil
+	}
+	rules, err := projectRewriteRules()
+	if err != nil {
+		return err
+	}
+	if metadata.InputDigest == InputDigest(path.Dir(packageFileName), deplist, rules) {
+		return nil
+	}
+
+	message := fmt.Sprintf("'%s' is out of date with '%s'; run 'grapnel update'",
+		lockFileName, packageFileName)
+	if metadata.InputDigest == "" {
+		message = fmt.Sprintf("'%s' has no record of what it was resolved from; run 'grapnel update'",
+			lockFileName)
+	}
+	if flagLocked {
+		return &exitError{message}
+	}
+	log.Warn("WARNING: %s", message)
+	return nil
+}
+
 func installFn(cmd *Command, args []string) error {
 	configureLogging()
 
@@ -61,24 +110,34 @@ func installFn(cmd *Command, args []string) error {
 	}
 	log.Info("loaded %d dependency definitions", len(deplist))
 
+	// local paths only make sense on the machine that wrote the lock file
+	for _, dep := range deplist {
+		if dep.IsLocal() {
+			log.Warn("WARNING: '%s' comes from a local path, not a repository.", dep.Import)
+			log.Warn("WARNING: This install is not reproducible on other machines.")
+		}
+	}
+
 	log.Info("installing to: %v", targetPath)
 	if err := os.MkdirAll(targetPath, 0755); err != nil {
 		return err
 	}
 
 	libs := []*Library{}
-	// cleanup
-	defer func() {
-		for _, lib := range libs {
-			lib.Destroy()
-		}
-	}()
-
 	// resolve all the dependencies
 	resolver, err := getResolver()
 	if err != nil {
 		return err
 	}
+	if err := checkLockMetadata(); err != nil {
+		return err
+	}
+	if flagOffline {
+		// report everything the cache can't supply, before doing anything else
+		if err := resolver.FindMissing(deplist); err != nil {
+			return err
+		}
+	}
 	libs, err = resolver.ResolveDependencies(deplist)
 	if err != nil {
 		return err
@@ -86,7 +145,9 @@ func installFn(cmd *Command, args []string) error {
 
 	// install all the dependencies
 	log.Info("Resolved %v dependencies. Installing.", len(libs))
-	resolver.InstallLibraries(targetPath, libs)
+	if err := resolver.InstallLibraries(targetPath, libs); err != nil {
+		return err
+	}
 
 	log.Info("Install complete")
 	return nil
@@ -95,8 +156,13 @@ func installFn(cmd *Command, args []string) error {
 var installCmd = Command{
 	Desc: "Downloads and installs locked dependencies.",
 	Help: " Installs packages at 'targetPath', from configured lock file.\n" +
+		" With --offline, everything must come from the download cache.\n" +
+		" A lock file that is out of date with the package file gets a warning,\n" +
+		" or with --locked, stops the install.  The package file is the one\n" +
+		" next to the lock file, and --locked requires it to be there.\n" +
 		"\nDefaults:\n" +
 		"  Lock file = " + defaultLockFileName + "\n" +
+		"  Package file = grapnel.toml, next to the lock file\n" +
 		"  Target path = " + defaultTargetPath + "\n",
 	Flags: FlagMap{
 		"lockfile": &Flag{
@@ -111,6 +177,14 @@ var installCmd = Command{
 			ArgDesc: "[target]",
 			Fn:      StringFlagFn(&targetPath),
 		},
+		"offline": &Flag{
+			Desc: "Use only the download cache, and never contact remotes",
+			Fn:   BoolFlagFn(&flagOffline),
+		},
+		"locked": &Flag{
+			Desc: "Refuse to install if the lock file is out of date",
+			Fn:   BoolFlagFn(&flagLocked),
+		},
 	},
 	Fn: installFn,
 }
@@ -28,6 +28,8 @@ import (
 	. "grapnel/flag"
 	log "grapnel/log"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 )
 
@@ -52,16 +54,48 @@ var (
 	flagQuiet   bool
 	flagVerbose bool
 	flagDebug   bool
+	flagOffline bool
+	flagJobs    int
 )
 
+// Returned by a command that ran to completion, but still needs to exit
+// non-zero.  No help is shown for these.
+type exitError struct {
+	message string
+}
+
+func (self *exitError) Error() string {
+	return self.message
+}
+
 func getResolver() (*Resolver, error) {
 	resolver := NewResolver()
-	resolver.LibSources["git"] = &GitSCM{}
-	resolver.LibSources["archive"] = &ArchiveSCM{}
+	if flagJobs < 0 {
+		return nil, fmt.Errorf("The number of jobs cannot be negative: %d", flagJobs)
+	} else if flagJobs > 0 {
+		resolver.Jobs = flagJobs
+	}
+
+	// all library sources share the download cache
+	cacheRoot, err := DefaultCacheRoot()
+	if err != nil {
+		return nil, err
+	}
+	cache := NewCache(cacheRoot)
+	cache.Offline = flagOffline
+	resolver.LibSources["git"] = &GitSCM{Cache: cache}
+	resolver.LibSources["archive"] = &ArchiveSCM{Cache: cache}
+	resolver.LibSources["hg"] = &HgSCM{Cache: cache}
+	resolver.LibSources["svn"] = &SvnSCM{Cache: cache}
+	resolver.LibSources["bzr"] = &BzrSCM{Cache: cache}
+	resolver.LibSources["path"] = &PathSCM{}
 
 	resolver.AddRewriteRules(BasicRewriteRules)
 	resolver.AddRewriteRules(GitRewriteRules)
 	resolver.AddRewriteRules(ArchiveRewriteRules)
+	resolver.AddRewriteRules(HgRewriteRules)
+	resolver.AddRewriteRules(SvnRewriteRules)
+	resolver.AddRewriteRules(BzrRewriteRules)
 
 	// find/validate configuration file
 	if configFileName != "" {
@@ -98,6 +132,17 @@ func getResolver() (*Resolver, error) {
 	return resolver, nil
 }
 
+// Returns the rewrite rules that belong to the project, rather than to
+// whoever runs grapnel: those in the config file next to the package file.
+// Only these go into the lock file's input digest.
+func projectRewriteRules() (RewriteRuleArray, error) {
+	filename := filepath.Join(filepath.Dir(packageFileName), path.Base(configFilePath[0]))
+	if !Exists(filename) {
+		return nil, nil
+	}
+	return LoadRewriteRules(filename)
+}
+
 func configureLogging() {
 	if flagDebug {
 		log.SetGlobalLogLevel(log.DEBUG)
@@ -134,6 +179,12 @@ var rootCmd = &Command{
 			Desc: "Debug output",
 			Fn:   BoolFlagFn(&flagDebug),
 		},
+		"jobs": &Flag{
+			Alias:   "j",
+			Desc:    fmt.Sprintf("Number of fetches to run at once (default %d)", DefaultJobs),
+			ArgDesc: "[count]",
+			Fn:      IntFlagFn(&flagJobs),
+		},
 		"config": &Flag{
 			Alias:   "c",
 			Desc:    "Configuration file",
@@ -146,12 +197,18 @@ var rootCmd = &Command{
 		},
 	},
 	Commands: CommandMap{
-		"install": &installCmd,
-		"update":  &updateCmd,
+		"add":      &addCmd,
+		"graph":    &graphCmd,
+		"install":  &installCmd,
+		"outdated": &outdatedCmd,
+		"remove":   &removeCmd,
+		"update":   &updateCmd,
+		"verify":   &verifyCmd,
 		"version": &Command{
 			Desc: "Version information",
 			Fn:   SimpleCommandFn(ShowVersion),
 		},
+		"why": &whyCmd,
 	},
 }
 
@@ -165,6 +222,9 @@ func GrapnelMain() {
 				"\n"+rootCmd.Help)
 	if err := rootCmd.Execute(os.Args...); err != nil {
 		log.Error(err)
-		rootCmd.ShowHelp(os.Args[0])
+		if _, ok := err.(*exitError); !ok {
+			rootCmd.ShowHelp(os.Args[0])
+		}
+		os.Exit(1)
 	}
 }
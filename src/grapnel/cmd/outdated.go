@@ -0,0 +1,167 @@
+package cmd
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	. "grapnel/flag"
+	. "grapnel/lib"
+	log "grapnel/log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// what the remote side has to offer for one locked library
+type outdatedRow struct {
+	lib      *Library
+	versions []*TaggedVersion
+	head     string
+	err      error
+}
+
+// The commit the library is locked to, if known: the tag itself when
+// unversioned, or the commit its version tag is on.
+func (self *outdatedRow) lockedCommit() string {
+	if self.lib.VersionSpec.IsUnversioned() {
+		return self.lib.Tag
+	}
+	for _, version := range self.versions {
+		if version.Tag == self.lib.Tag {
+			return version.Commit
+		}
+	}
+	return ""
+}
+
+func (self *outdatedRow) columns(spec *VersionSpec) []string {
+	wanted, latest, moved := "-", "-", "-"
+	if self.err != nil {
+		return []string{self.lib.Import, self.lib.Selection(), "error", "error", "error"}
+	}
+	if spec != nil && !spec.IsUnversioned() {
+		if version := NewestVersion(self.versions, spec); version != nil {
+			wanted = version.Version.String()
+		}
+	}
+	// the newest of everything, prereleases included
+	if version := NewestVersion(self.versions, NewVersionSpec(OpEq, -1, -1, -1)); version != nil &&
+		version.Version.Major >= 0 {
+		latest = version.Version.String()
+	}
+	if commit := self.lockedCommit(); self.head != "" && commit != "" {
+		if strings.HasPrefix(self.head, commit) {
+			moved = "no"
+		} else {
+			moved = "yes"
+		}
+	}
+	return []string{self.lib.Import, self.lib.Selection(), wanted, latest, moved}
+}
+
+func outdatedFn(cmd *Command, args []string) error {
+	configureLogging()
+
+	if len(args) > 0 {
+		return fmt.Errorf("Too many arguments for 'outdated'")
+	}
+	setPackageDefaults()
+
+	deplist, err := loadPackageFile()
+	if err != nil {
+		return err
+	}
+	specs := map[string]*VersionSpec{}
+	for _, dep := range deplist {
+		specs[dep.Import] = dep.VersionSpec
+	}
+
+	lockDeps, err := LoadGrapnelDepsfile(lockFileName)
+	if err != nil {
+		return err
+	} else if lockDeps == nil {
+		return fmt.Errorf("Cannot open lock file: '%s'", lockFileName)
+	}
+
+	resolver, err := getResolver()
+	if err != nil {
+		return err
+	}
+
+	// ask each library source what is out there
+	rows := make([]*outdatedRow, len(lockDeps))
+	done := make(chan bool)
+	slots := make(chan bool, resolver.Jobs)
+	for ii, dep := range lockDeps {
+		rows[ii] = &outdatedRow{lib: NewLockedLibrary(dep)}
+		go func(row *outdatedRow, dep *Dependency) {
+			defer func() { done <- true }()
+			slots <- true
+			defer func() { <-slots }()
+			// each call applies the rewrite rules to what it's given
+			if row.versions, row.err = resolver.ListVersions(dep.Copy()); row.err != nil {
+				return
+			}
+			row.head, row.err = resolver.BranchHead(dep.Copy())
+		}(rows[ii], dep)
+	}
+	for range lockDeps {
+		<-done
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "IMPORT\tCURRENT\tWANTED\tLATEST\tHEAD MOVED\n")
+	for _, row := range rows {
+		if row.err != nil {
+			log.Warn("%s: %v", row.lib.Import, row.err)
+		}
+		fmt.Fprintf(writer, "%s\n", strings.Join(row.columns(specs[row.lib.Import]), "\t"))
+	}
+	return writer.Flush()
+}
+
+var outdatedCmd = Command{
+	Desc: "Compares locked dependencies with what is available.",
+	Help: " Lists each library in the lock file with its current version or commit,\n" +
+		" the newest version that satisfies the package file, the newest version\n" +
+		" overall, and whether the head of its branch has moved.  Nothing is\n" +
+		" installed or written.\n" +
+		"\nDefaults:\n" +
+		"  Package file = " + defaultPackageFileName + "\n" +
+		"  Lock file = " + defaultLockFileName + "\n",
+	Flags: FlagMap{
+		"pconfig": &Flag{
+			Alias:   "p",
+			Desc:    "Grapnel package file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&packageFileName),
+		},
+		"lockfile": &Flag{
+			Alias:   "l",
+			Desc:    "Grapnel lock file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&lockFileName),
+		},
+	},
+	Fn: outdatedFn,
+}
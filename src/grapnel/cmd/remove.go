@@ -0,0 +1,152 @@
+package cmd
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	. "grapnel/flag"
+	. "grapnel/lib"
+	log "grapnel/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func removeFn(cmd *Command, args []string) error {
+	configureLogging()
+
+	if len(args) == 0 {
+		return fmt.Errorf("Missing import for 'remove'")
+	} else if len(args) > 1 {
+		return fmt.Errorf("Too many arguments for 'remove'")
+	}
+	importPath := args[0]
+	setPackageDefaults()
+
+	// strip the entry out of the package file text
+	info, err := os.Stat(packageFileName)
+	if err != nil {
+		return fmt.Errorf("Cannot open grapnel file: '%s'", packageFileName)
+	}
+	data, err := ioutil.ReadFile(packageFileName)
+	if err != nil {
+		return err
+	}
+	content, err := RemoveDependencyEntry(string(data), importPath)
+	if err != nil {
+		return err
+	}
+
+	// figure out what's left
+	deplist, err := loadPackageFile()
+	if err != nil {
+		return err
+	}
+	remaining := []*Dependency{}
+	for _, dep := range deplist {
+		if dep.Import != importPath {
+			remaining = append(remaining, dep)
+		}
+	}
+
+	metadata, err := newLockMetadata(remaining)
+	if err != nil {
+		return err
+	}
+
+	// find everything in the lock file that nothing needs any more
+	libs, err := LoadLockedLibraries(lockFileName, targetPath)
+	if err != nil {
+		return err
+	}
+	// what each library needs is read from its installed copy, so a missing
+	// one would look like it needs nothing
+	for _, lib := range libs {
+		if !Exists(filepath.Join(targetPath, lib.Import)) {
+			return fmt.Errorf("Locked library '%s' is not installed under '%s'; run 'grapnel install' first",
+				lib.Import, targetPath)
+		}
+	}
+	keep := ReachableLibraries(remaining, libs)
+	kept := map[*Library]bool{}
+	for _, lib := range keep {
+		kept[lib] = true
+	}
+
+	log.Info("Removing %s from %s", importPath, packageFileName)
+	if err := ioutil.WriteFile(packageFileName, []byte(content), info.Mode()); err != nil {
+		return err
+	}
+	keepImports := []string{}
+	for _, lib := range keep {
+		keepImports = append(keepImports, lib.Import)
+	}
+	for _, lib := range libs {
+		if !kept[lib] {
+			log.Info("Uninstalling: %s", lib.Import)
+			if err := lib.Uninstall(targetPath, keepImports); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writeLockFile(metadata, keep); err != nil {
+		return err
+	}
+
+	log.Info("Remove complete")
+	return nil
+}
+
+var removeCmd = Command{
+	Desc:    "Removes a dependency, and any libraries that are no longer needed.",
+	ArgDesc: "[import]",
+	Help: " Removes the dependency from the package file, then uninstalls it and\n" +
+		" any locked libraries that nothing else needs, and rewrites the lock file.\n" +
+		" Every locked library must be installed, since that is where the libraries\n" +
+		" each one needs are read from.\n" +
+		"\nDefaults:\n" +
+		"  Package file = " + defaultPackageFileName + "\n" +
+		"  Lock file = " + defaultLockFileName + "\n" +
+		"  Target path = " + defaultTargetPath + "\n",
+	Flags: FlagMap{
+		"pconfig": &Flag{
+			Alias:   "p",
+			Desc:    "Grapnel package file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&packageFileName),
+		},
+		"lockfile": &Flag{
+			Alias:   "l",
+			Desc:    "Grapnel lock file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&lockFileName),
+		},
+		"target": &Flag{
+			Alias:   "t",
+			Desc:    "Target installation path",
+			ArgDesc: "[target]",
+			Fn:      StringFlagFn(&targetPath),
+		},
+	},
+	Fn: removeFn,
+}
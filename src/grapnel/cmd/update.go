@@ -35,14 +35,8 @@ var (
 	createDsd bool = false
 )
 
-func updateFn(cmd *Command, args []string) error {
-	configureLogging()
-
-	if len(args) > 0 {
-		return fmt.Errorf("Too many arguments for 'update'")
-	}
-
-	// set unset paramters to the defaults
+// set unset paramters to the defaults, for commands that work from the package file
+func setPackageDefaults() {
 	if packageFileName == "" {
 		packageFileName = defaultPackageFileName
 		if lockFileName == "" {
@@ -60,57 +54,95 @@ func updateFn(cmd *Command, args []string) error {
 	log.Debug("package file: %v", packageFileName)
 	log.Debug("lock file: %v", lockFileName)
 	log.Debug("target path: %v", targetPath)
+}
 
-	// get dependencies from the grapnel file
+// get dependencies from the grapnel file
+func loadPackageFile() ([]*Dependency, error) {
 	log.Info("loading package file: '%s'", packageFileName)
 	deplist, err := LoadGrapnelDepsfile(packageFileName)
 	if err != nil {
-		return err
+		return nil, err
 	} else if deplist == nil {
-		// TODO: fail over to update instead?
-		return fmt.Errorf("Cannot open grapnel file: '%s'", packageFileName)
+		return nil, fmt.Errorf("Cannot open grapnel file: '%s'", packageFileName)
 	}
 	log.Info("loaded %d dependency definitions", len(deplist))
+	return deplist, nil
+}
 
-	// open it now before we expend any real effort
+// describes what a lock file is resolved from, before resolution rewrites anything
+func newLockMetadata(deplist []*Dependency) (*LockMetadata, error) {
+	rules, err := projectRewriteRules()
+	if err != nil {
+		return nil, err
+	}
+	return &LockMetadata{
+		SchemaVersion:  LockSchemaVersion,
+		GrapnelVersion: VERSION,
+		InputDigest:    InputDigest(path.Dir(packageFileName), deplist, rules),
+	}, nil
+}
+
+// write the library data out
+func writeLockFile(metadata *LockMetadata, libs []*Library) error {
+	log.Info("Writing lock file")
 	lockFile, err := os.Create(lockFileName)
-	defer lockFile.Close()
 	if err != nil {
 		log.Error("Cannot open lock file: '%s'", lockFileName)
 		return err
 	}
+	defer lockFile.Close()
+	return WriteLockFile(lockFile, path.Dir(lockFileName), metadata, libs)
+}
 
+// install all the dependencies
+func installLibraries(resolver *Resolver, libs []*Library) error {
 	log.Info("installing to: %v", targetPath)
 	if err := os.MkdirAll(targetPath, 0755); err != nil {
 		return err
 	}
+	log.Info("Resolved %v dependencies. Installing.", len(libs))
+	return resolver.InstallLibraries(targetPath, libs)
+}
 
-	libs := []*Library{}
-	// cleanup
-	defer func() {
-		for _, lib := range libs {
-			lib.Destroy()
-		}
-	}()
+func updateFn(cmd *Command, args []string) error {
+	configureLogging()
+
+	if len(args) > 0 {
+		return fmt.Errorf("Too many arguments for 'update'")
+	}
+	setPackageDefaults()
+
+	deplist, err := loadPackageFile()
+	if err != nil {
+		return err
+	}
 
+	libs := []*Library{}
 	// resolve all the dependencies
 	resolver, err := getResolver()
 	if err != nil {
 		return err
 	}
+	metadata, err := newLockMetadata(deplist)
+	if err != nil {
+		return err
+	}
+	if flagOffline {
+		// report everything the cache can't supply, before doing anything else
+		if err := resolver.FindMissing(deplist); err != nil {
+			return err
+		}
+	}
 	libs, err = resolver.ResolveDependencies(deplist)
 	if err != nil {
 		return err
 	}
 
-	// install all the dependencies
-	log.Info("Resolved %v dependencies. Installing.", len(libs))
-	resolver.InstallLibraries(targetPath, libs)
-
-	// write the library data out
-	log.Info("Writing lock file")
-	for _, lib := range libs {
-		lib.ToToml(lockFile)
+	if err := installLibraries(resolver, libs); err != nil {
+		return err
+	}
+	if err := writeLockFile(metadata, libs); err != nil {
+		return err
 	}
 
 	if createDsd {
@@ -128,6 +160,7 @@ func updateFn(cmd *Command, args []string) error {
 var updateCmd = Command{
 	Desc: "Downloads and installs dependencies that need to be updated.",
 	Help: " Installs packages at 'targetPath', from configured package file.\n" +
+		" With --offline, everything must come from the download cache.\n" +
 		"\nDefaults:\n" +
 		"  Package file = " + defaultPackageFileName + "\n" +
 		"  Lock file = " + defaultLockFileName + "\n" +
@@ -156,6 +189,10 @@ var updateCmd = Command{
 			Desc:  "Create a 'dead-simple-downloader' script'",
 			Fn:    BoolFlagFn(&createDsd),
 		},
+		"offline": &Flag{
+			Desc: "Use only the download cache, and never contact remotes",
+			Fn:   BoolFlagFn(&flagOffline),
+		},
 	},
 	Fn: updateFn,
 }
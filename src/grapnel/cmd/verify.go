@@ -0,0 +1,147 @@
+package cmd
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	. "grapnel/flag"
+	. "grapnel/lib"
+)
+
+var (
+	verifyFormat = "text"
+	verifyFiles  = false
+)
+
+func verifyFn(cmd *Command, args []string) error {
+	configureLogging()
+
+	if len(args) > 0 {
+		return fmt.Errorf("Too many arguments for 'verify'")
+	}
+	switch verifyFormat {
+	case "json", "text":
+	default:
+		return fmt.Errorf("Unknown output format: '%s'", verifyFormat)
+	}
+	setPackageDefaults()
+
+	deplist, err := loadPackageFile()
+	if err != nil {
+		return err
+	}
+	lockDeps, err := LoadGrapnelDepsfile(lockFileName)
+	if err != nil {
+		return err
+	} else if lockDeps == nil {
+		return fmt.Errorf("Cannot open lock file: '%s'", lockFileName)
+	}
+	locked := []*Library{}
+	for _, dep := range lockDeps {
+		locked = append(locked, NewLockedLibrary(dep))
+	}
+
+	// naming the files that differ means fetching the locked revisions
+	var resolver *Resolver
+	if verifyFiles {
+		if resolver, err = getResolver(); err != nil {
+			return err
+		}
+	}
+	drifts, err := VerifyInstall(deplist, locked, targetPath, resolver)
+	if err != nil {
+		return err
+	}
+
+	switch verifyFormat {
+	case "json":
+		data, err := json.MarshalIndent(drifts, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", data)
+	case "text":
+		for _, drift := range drifts {
+			fmt.Println(drift.String())
+		}
+	}
+
+	if len(drifts) > 0 {
+		return &exitError{fmt.Sprintf("Found %d problems with '%s'", len(drifts), targetPath)}
+	}
+	return nil
+}
+
+var verifyCmd = Command{
+	Desc: "Checks installed dependencies against the lock file.",
+	Help: " Reports locked libraries that are missing, directories that are not in\n" +
+		" the lock file, libraries that differ from the locked revision, and lock\n" +
+		" entries that no longer satisfy the package file.  Nothing is installed\n" +
+		" or written.  With --files, the locked revision of each modified library\n" +
+		" is fetched into the download cache, to name the files that differ.\n" +
+		" Exits non-zero if there are any problems.\n" +
+		"\nFormats:\n" +
+		"  text = one problem per line: kind, import, path, detail\n" +
+		"  json = a list of problems as JSON\n" +
+		"\nDefaults:\n" +
+		"  Package file = " + defaultPackageFileName + "\n" +
+		"  Lock file = " + defaultLockFileName + "\n" +
+		"  Target path = " + defaultTargetPath + "\n" +
+		"  Format = text\n",
+	Flags: FlagMap{
+		"pconfig": &Flag{
+			Alias:   "p",
+			Desc:    "Grapnel package file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&packageFileName),
+		},
+		"lockfile": &Flag{
+			Alias:   "l",
+			Desc:    "Grapnel lock file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&lockFileName),
+		},
+		"target": &Flag{
+			Alias:   "t",
+			Desc:    "Target installation path",
+			ArgDesc: "[target]",
+			Fn:      StringFlagFn(&targetPath),
+		},
+		"format": &Flag{
+			Alias:   "f",
+			Desc:    "Output format: text or json",
+			ArgDesc: "[format]",
+			Fn:      StringFlagFn(&verifyFormat),
+		},
+		"files": &Flag{
+			Desc: "Name the files that differ, fetching locked revisions as needed",
+			Fn:   BoolFlagFn(&verifyFiles),
+		},
+		"offline": &Flag{
+			Desc: "Use only the download cache, and never contact remotes",
+			Fn:   BoolFlagFn(&flagOffline),
+		},
+	},
+	Fn: verifyFn,
+}
@@ -0,0 +1,113 @@
+package cmd
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	. "grapnel/flag"
+	. "grapnel/lib"
+)
+
+var whyResolve bool
+
+func whyFn(cmd *Command, args []string) error {
+	configureLogging()
+
+	if len(args) == 0 {
+		return fmt.Errorf("Missing import for 'why'")
+	} else if len(args) > 1 {
+		return fmt.Errorf("Too many arguments for 'why'")
+	}
+	importPath := args[0]
+	setPackageDefaults()
+
+	deplist, err := loadPackageFile()
+	if err != nil {
+		return err
+	}
+
+	var graph *Graph
+	if whyResolve {
+		resolver, err := getResolver()
+		if err != nil {
+			return err
+		}
+		if graph, err = resolver.ResolveGraph(deplist); err != nil {
+			return err
+		}
+	} else {
+		libs, err := LoadLockedLibraries(lockFileName, targetPath)
+		if err != nil {
+			return err
+		}
+		graph = NewGraph(deplist, libs)
+	}
+
+	paths := graph.Paths(importPath)
+	if len(paths) == 0 {
+		return fmt.Errorf("Nothing in '%s' requires '%s'", packageFileName, importPath)
+	}
+	for _, path := range paths {
+		fmt.Println(graph.PathString(path))
+	}
+	return nil
+}
+
+var whyCmd = Command{
+	Desc:    "Shows why a package is needed.",
+	ArgDesc: "[import]",
+	Help: " Prints each chain of dependencies from the package file to the library\n" +
+		" that provides the import.  Each step shows the version asked for, and the\n" +
+		" version picked.  The graph comes from the lock file and installed\n" +
+		" libraries, unless --resolve is given.\n" +
+		"\nDefaults:\n" +
+		"  Package file = " + defaultPackageFileName + "\n" +
+		"  Lock file = " + defaultLockFileName + "\n" +
+		"  Target path = " + defaultTargetPath + "\n",
+	Flags: FlagMap{
+		"pconfig": &Flag{
+			Alias:   "p",
+			Desc:    "Grapnel package file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&packageFileName),
+		},
+		"lockfile": &Flag{
+			Alias:   "l",
+			Desc:    "Grapnel lock file",
+			ArgDesc: "[filename]",
+			Fn:      StringFlagFn(&lockFileName),
+		},
+		"target": &Flag{
+			Alias:   "t",
+			Desc:    "Target installation path",
+			ArgDesc: "[target]",
+			Fn:      StringFlagFn(&targetPath),
+		},
+		"resolve": &Flag{
+			Alias: "r",
+			Desc:  "Resolve the package file instead of reading the lock file",
+			Fn:    BoolFlagFn(&whyResolve),
+		},
+	},
+	Fn: whyFn,
+}
@@ -24,6 +24,7 @@ THE SOFTWARE.
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -203,6 +204,20 @@ func StringFlagFn(ptr *string) FlagFn {
 	}
 }
 
+func IntFlagFn(ptr *int) FlagFn {
+	return func(name string, values []string) (int, error) {
+		if len(values) == 0 {
+			return 0, fmt.Errorf("Flag %v requires a value", name)
+		}
+		value, err := strconv.Atoi(values[0])
+		if err != nil {
+			return 0, fmt.Errorf("Flag %v requires a number", name)
+		}
+		(*ptr) = value
+		return 1, nil
+	}
+}
+
 // Basic proxy for a simple func to run when a flag is used
 func SimpleFlagFn(fn func() error) FlagFn {
 	return func(name string, values []string) (int, error) {
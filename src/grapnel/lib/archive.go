@@ -23,63 +23,355 @@ THE SOFTWARE.
 */
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
 	"fmt"
+	"github.com/ulikunitz/xz"
 	log "grapnel/log"
+	url "grapnel/url"
+	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
 var ArchiveRewriteRules = RewriteRuleArray{
-	TypeResolverRule("path", `^.*\.zip$`, `archive`),
-	TypeResolverRule("path", `^.*\.tar.gz$`, `archive`),
-	TypeResolverRule("path", `^.*\.tar$`, `archive`),
+	TypeResolverRule("archive-zip", "path", `^.*\.zip$`, `archive`),
+	TypeResolverRule("archive-tar.gz", "path", `^.*\.(tar\.gz|tgz)$`, `archive`),
+	TypeResolverRule("archive-tar.bz2", "path", `^.*\.(tar\.bz2|tbz2)$`, `archive`),
+	TypeResolverRule("archive-tar.xz", "path", `^.*\.(tar\.xz|txz)$`, `archive`),
+	TypeResolverRule("archive-tar", "path", `^.*\.tar$`, `archive`),
 }
 
-type ArchiveSCM struct{}
+type ArchiveSCM struct {
+	Cache *Cache
+}
 
-func (self *ArchiveSCM) Resolve(dep *Dependency) (*Library, error) {
-	lib := NewLibrary(dep)
+// Downloads an archive, and returns its path along with the hex sha256
+// digest of its contents.
+func (self *ArchiveSCM) download(archiveUrl *url.URL) (string, string, error) {
+	filename, err := self.Cache.Download(archiveUrl)
+	if err != nil {
+		return "", "", err
+	}
+	sum, err := FileHash(filename)
+	if err != nil {
+		return "", "", err
+	}
+	return filename, strings.TrimPrefix(sum, "sha256:"), nil
+}
+
+// a file, directory, symlink or hard link within an archive
+type archiveEntry struct {
+	Name     string
+	Mode     os.FileMode
+	Linkname string
+	Hardlink bool // Linkname is an earlier entry that this one is a copy of
+	Open     func() (io.ReadCloser, error)
+}
 
-	// create a dedicated directory and a context for commands
-	tempRoot, err := ioutil.TempDir("", "")
+// calls fn for each file, directory and symlink in a zip archive
+func walkZip(filename string, fn func(*archiveEntry) error) error {
+	reader, err := zip.OpenReader(filename)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		entry := &archiveEntry{
+			Name: file.Name,
+			Mode: file.Mode(),
+			Open: file.Open,
+		}
+		if entry.Mode&os.ModeSymlink != 0 {
+			link, err := readAll(entry.Open)
+			if err != nil {
+				return err
+			}
+			entry.Linkname = string(link)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// calls fn for each file, directory, symlink and hard link in a tar stream
+func walkTar(stream io.Reader, fn func(*archiveEntry) error) error {
+	reader := tar.NewReader(stream)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+		default:
+			log.Debug("Skipping archive entry: %s", header.Name)
+			continue
+		}
+		entry := &archiveEntry{
+			Name:     header.Name,
+			Mode:     header.FileInfo().Mode(),
+			Linkname: header.Linkname,
+			Hardlink: header.Typeflag == tar.TypeLink,
+			Open: func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(reader), nil
+			},
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
 	}
-	lib.TempDir = tempRoot
-	cmd := NewRunContext(tempRoot)
+}
 
-	// prep archive file for write
-	filename := filepath.Join(tempRoot, filepath.Base(lib.Dependency.Url.Path))
-	file, err := os.OpenFile(filename, os.O_CREATE, 0)
+// Calls fn for each file, directory and link in an archive, going by the
+// archive's file extension.
+func walkArchive(filename string, fn func(*archiveEntry) error) error {
+	if strings.HasSuffix(filename, ".zip") {
+		return walkZip(filename, fn)
+	}
+
+	file, err := os.Open(filename)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot open archive for writing: %v", err)
+		return err
 	}
 	defer file.Close()
+	switch {
+	case hasAnySuffix(filename, ".tar.gz", ".tgz"):
+		stream, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		return walkTar(stream, fn)
+	case hasAnySuffix(filename, ".tar.bz2", ".tbz2"):
+		return walkTar(bzip2.NewReader(file), fn)
+	case hasAnySuffix(filename, ".tar.xz", ".txz"):
+		stream, err := xz.NewReader(file)
+		if err != nil {
+			return err
+		}
+		return walkTar(stream, fn)
+	case strings.HasSuffix(filename, ".tar"):
+		return walkTar(file, fn)
+	}
+	return fmt.Errorf("Unsupported archive type: '%s'", filepath.Base(filename))
+}
+
+// Parses the version out of an archive filename, like 'foo-1.2.0-rc.1.tar.gz'
+func archiveVersion(dep *Dependency, filename string) (*Version, error) {
+	name := filepath.Base(filename)
+	for _, suffix := range []string{".zip", ".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2",
+		".tar.xz", ".txz"} {
+		if strings.HasSuffix(name, suffix) {
+			name = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+	return dep.ParseTag(name)
+}
+
+func hasAnySuffix(value string, suffixes ...string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(value, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func readAll(open func() (io.ReadCloser, error)) ([]byte, error) {
+	reader, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// Cleans up the name of an archive entry, and makes sure that it stays
+// inside of the archive.  Returns "" for the archive root.
+func archiveEntryName(name string) (string, error) {
+	name = strings.Replace(name, "\\", "/", -1)
+	cleaned := path.Clean(name)
+	if path.IsAbs(name) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("Archive entry is outside of the archive: '%s'", name)
+	} else if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// Returns the single directory that every entry in an archive lives under,
+// or "" if there isn't one.
+func archiveTopDir(filename string) (string, error) {
+	topDir := ""
+	err := walkArchive(filename, func(entry *archiveEntry) error {
+		name, err := archiveEntryName(entry.Name)
+		if err != nil || name == "" {
+			return err
+		}
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) == 1 && !entry.Mode.IsDir() {
+			topDir = "/" // a file at the top
+		} else if topDir == "" {
+			topDir = parts[0]
+		} else if topDir != parts[0] {
+			topDir = "/" // more than one thing at the top
+		}
+		return nil
+	})
+	if err != nil || topDir == "/" {
+		return "", err
+	}
+	return topDir, nil
+}
+
+// Refuses to write an entry through a link that extraction has already made,
+// since a chain of links can lead out of dir even if each one stays inside.
+func checkArchivePath(dir string, name string) error {
+	current := dir
+	for _, part := range strings.Split(name, "/") {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil // nothing further along can exist either
+		} else if err != nil {
+			return err
+		} else if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("Archive entry is written through a link: '%s'", name)
+		}
+	}
+	return nil
+}
 
-	// get the targeted archive
-	response, err := http.Get(lib.Dependency.Url.String())
+// Unpacks an archive into dir.  If everything in the archive is under one
+// directory, as with most release tarballs, that directory is left out.
+func extractArchive(filename string, dir string) error {
+	topDir, err := archiveTopDir(filename)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot download archive: %v", err)
+		return fmt.Errorf("Cannot read archive '%s': %v", filepath.Base(filename), err)
 	}
-	if err := response.Write(file); err != nil {
-		return nil, fmt.Errorf("Cannot write archive: %v", err)
+
+	// names an entry by where it goes under dir
+	entryName := func(name string) (string, error) {
+		name, err := archiveEntryName(name)
+		if err != nil {
+			return "", err
+		}
+		if topDir != "" {
+			name = strings.TrimPrefix(strings.TrimPrefix(name, topDir), "/")
+		}
+		return name, nil
 	}
-	file.Close()
-	log.Info("Wrote: %s", filename)
 
-	// extract the file
-	// TODO: change to using built-in libraries whenever possible.
-	switch filepath.Ext(filename) {
-	case "zip":
-		cmd.Run("unzip", filename)
-	case "tar.gz":
-		cmd.Run("tar", "xzf", filename)
-	case "tar":
-		cmd.Run("tar", "xf", filename)
+	err = walkArchive(filename, func(entry *archiveEntry) error {
+		name, err := entryName(entry.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			return nil
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		log.Debug("Extracting: %s", target)
+		if err := checkArchivePath(dir, name); err != nil {
+			return err
+		}
+
+		if entry.Mode.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if entry.Mode&os.ModeSymlink != 0 {
+			// links may only point at other things in the archive
+			link := path.Join(path.Dir(name), filepath.ToSlash(entry.Linkname))
+			if path.IsAbs(entry.Linkname) || link == ".." || strings.HasPrefix(link, "../") {
+				return fmt.Errorf("Archive link is outside of the archive: '%s'", entry.Name)
+			}
+			return os.Symlink(entry.Linkname, target)
+		}
+
+		open := entry.Open
+		if entry.Hardlink {
+			// hard links are extracted as copies of a file extracted already
+			source, err := entryName(entry.Linkname)
+			if err != nil {
+				return err
+			} else if err := checkArchivePath(dir, source); err != nil {
+				return err
+			}
+			sourcePath := filepath.Join(dir, filepath.FromSlash(source))
+			if info, err := os.Lstat(sourcePath); source == "" || source == name || err != nil || !info.Mode().IsRegular() {
+				return fmt.Errorf("Archive link is not to a file earlier in the archive: '%s'", entry.Name)
+			}
+			open = func() (io.ReadCloser, error) {
+				return os.Open(sourcePath)
+			}
+		}
+		reader, err := open()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, entry.Mode.Perm()|0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(file, reader)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Cannot extract archive '%s': %v", filepath.Base(filename), err)
+	}
+	return nil
+}
+
+func (self *ArchiveSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+	if lib.Url == nil {
+		return nil, fmt.Errorf("No url for dependency: '%s'", lib.Import)
+	}
+
+	// get the targeted archive, and check it before doing anything else
+	filename, digest, err := self.download(lib.Url)
+	if err == nil && dep.Sha256 != "" && digest != dep.Sha256 && !self.Cache.Offline {
+		// the cached copy may be out of date; try once more
+		log.Warn("Downloading '%s' again; the cached copy has the wrong sha256", lib.Url.String())
+		self.Cache.DiscardDownload(lib.Url)
+		filename, digest, err = self.download(lib.Url)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dep.Sha256 != "" && digest != dep.Sha256 {
+		self.Cache.DiscardDownload(lib.Url)
+		return nil, fmt.Errorf("Checksum mismatch for '%s': expected sha256 %s, downloaded %s",
+			lib.Import, dep.Sha256, digest)
+	}
+	lib.Sha256 = digest
+
+	// extract the file, unless its contents are already in the cache
+	key := CacheKey("archive", lib.Url.String(), digest)
+	lib.SourceDir, err = self.Cache.SourceTree(key, func(dir string) error {
+		return extractArchive(filename, dir)
+	})
+	if err != nil {
+		// whatever was downloaded is no good; fetch it again next time
+		self.Cache.DiscardDownload(lib.Url)
+		return nil, err
 	}
-	os.Remove(filename)
 
 	// Stop now if we have no semantic version information
 	if lib.VersionSpec.IsUnversioned() {
@@ -89,7 +381,7 @@ func (self *ArchiveSCM) Resolve(dep *Dependency) (*Library, error) {
 	}
 
 	// get the version number from the filename
-	if ver, err := ParseVersion(filepath.Base(filename)); err == nil {
+	if ver, err := archiveVersion(dep, filename); err == nil {
 		log.Debug("ver: %v", ver)
 		if dep.VersionSpec.IsSatisfiedBy(ver) {
 			lib.Version = ver
@@ -107,6 +399,30 @@ func (self *ArchiveSCM) Resolve(dep *Dependency) (*Library, error) {
 	return lib, nil
 }
 
+// the only version on offer is the one in the archive filename
+func (self *ArchiveSCM) ListVersions(dep *Dependency) ([]*TaggedVersion, error) {
+	ver, err := archiveVersion(dep, dep.Url.Path)
+	if err != nil {
+		log.Debug("Parse archive version err: %v", err)
+		return []*TaggedVersion{}, nil
+	}
+	return []*TaggedVersion{&TaggedVersion{Version: ver}}, nil
+}
+
+// an archive is available once it has been downloaded
+func (self *ArchiveSCM) Available(dep *Dependency) error {
+	if dep.Url == nil {
+		return fmt.Errorf("No url for dependency: '%s'", dep.Import)
+	}
+	_, err := self.Cache.Download(dep.Url)
+	return err
+}
+
+// archives have no branches to speak of
+func (self *ArchiveSCM) BranchHead(dep *Dependency) (string, error) {
+	return "", nil
+}
+
 func (self *ArchiveSCM) ToDSD(*Library) string {
 	return ""
 }
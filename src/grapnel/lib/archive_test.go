@@ -0,0 +1,307 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"github.com/ulikunitz/xz"
+	. "grapnel/testing"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a file in a test archive; directories end in '/', and links have a target
+type testArchiveEntry struct {
+	Name     string
+	Body     string
+	Target   string
+	Hardlink bool
+}
+
+func writeTestTar(writer io.Writer, entries []testArchiveEntry) error {
+	archive := tar.NewWriter(writer)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.Name, Mode: 0644, Size: int64(len(entry.Body))}
+		if entry.Hardlink {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = entry.Target
+		} else if entry.Target != "" {
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = entry.Target
+		} else if entry.Name[len(entry.Name)-1] == '/' {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := archive.Write([]byte(entry.Body)); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func writeTestArchive(filename string, entries []testArchiveEntry) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch filepath.Ext(filename) {
+	case ".zip":
+		archive := zip.NewWriter(file)
+		for _, entry := range entries {
+			header := &zip.FileHeader{Name: entry.Name}
+			if entry.Target != "" {
+				header.SetMode(os.ModeSymlink | 0777)
+				entry.Body = entry.Target
+			}
+			writer, err := archive.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			if _, err := writer.Write([]byte(entry.Body)); err != nil {
+				return err
+			}
+		}
+		return archive.Close()
+	case ".gz":
+		stream := gzip.NewWriter(file)
+		if err := writeTestTar(stream, entries); err != nil {
+			return err
+		}
+		return stream.Close()
+	case ".xz":
+		stream, err := xz.NewWriter(file)
+		if err != nil {
+			return err
+		}
+		if err := writeTestTar(stream, entries); err != nil {
+			return err
+		}
+		return stream.Close()
+	}
+	return writeTestTar(file, entries)
+}
+
+func TestExtractArchive(t *testing.T) {
+	InitTestLogging()
+	basePath, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(basePath)
+
+	released := []testArchiveEntry{
+		{Name: "foo-1.0/"},
+		{Name: "foo-1.0/foo.go", Body: "package foo"},
+		{Name: "foo-1.0/sub/sub.go", Body: "package sub"},
+		{Name: "foo-1.0/link.go", Target: "foo.go"},
+	}
+	loose := []testArchiveEntry{
+		{Name: "foo.go", Body: "package foo"},
+		{Name: "sub/sub.go", Body: "package sub"},
+	}
+
+	for ii, test := range []struct {
+		Filename string
+		Entries  []testArchiveEntry
+		Files    []string
+		Fails    bool
+	}{
+		{"foo-1.0.zip", released, []string{"foo.go", "sub/sub.go", "link.go"}, false},
+		{"foo-1.0.tar.gz", released, []string{"foo.go", "sub/sub.go", "link.go"}, false},
+		{"foo-1.0.tar", loose, []string{"foo.go", "sub/sub.go"}, false},
+		{"single.tar", loose[1:], []string{"sub.go"}, false},
+		{"foo-1.0.tar.xz", released, []string{"foo.go", "sub/sub.go", "link.go"}, false},
+		{"hardlink.tar", append(released[:3:3], testArchiveEntry{
+			Name: "foo-1.0/copy.go", Target: "foo-1.0/foo.go", Hardlink: true,
+		}), []string{"foo.go", "copy.go"}, false},
+		{"hardlink-missing.tar", []testArchiveEntry{
+			{Name: "foo.go", Target: "bar.go", Hardlink: true},
+		}, nil, true},
+		{"hardlink-outside.tar", []testArchiveEntry{
+			{Name: "foo.go", Target: "../../etc/passwd", Hardlink: true},
+		}, nil, true},
+		{"dotdot.zip", []testArchiveEntry{
+			{Name: "foo.go", Body: "package foo"},
+			{Name: "../evil.go", Body: "package evil"},
+		}, nil, true},
+		{"absolute.tar", []testArchiveEntry{
+			{Name: "/tmp/evil.go", Body: "package evil"},
+		}, nil, true},
+		{"link.tar.gz", []testArchiveEntry{
+			{Name: "foo/foo.go", Body: "package foo"},
+			{Name: "foo/evil", Target: "../../.."},
+		}, nil, true},
+		{"chain.tar", []testArchiveEntry{
+			{Name: "pkg/a/b", Target: ".."},
+			{Name: "pkg/a/b/c", Target: ".."},
+			{Name: "pkg/a/b/c/evil.txt", Body: "evil"},
+		}, nil, true},
+	} {
+		filename := filepath.Join(basePath, test.Filename)
+		if err := writeTestArchive(filename, test.Entries); err != nil {
+			t.Fatalf("%v", err)
+		}
+		dir := filepath.Join(basePath, "out", test.Filename)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		err := extractArchive(filename, dir)
+		if test.Fails {
+			if err == nil {
+				t.Errorf("Test %d: expected an error extracting %v", ii, test.Filename)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("Test %d: %v", ii, err)
+			continue
+		}
+		for _, file := range test.Files {
+			if !Exists(filepath.Join(dir, file)) {
+				t.Errorf("Test %d: expected %v in %v", ii, file, test.Filename)
+			}
+		}
+	}
+
+	// hard links come out as copies of their targets
+	if data, err := ioutil.ReadFile(filepath.Join(basePath, "out", "hardlink.tar", "copy.go")); err != nil {
+		t.Errorf("%v", err)
+	} else if string(data) != "package foo" {
+		t.Errorf("Expected a copy of foo.go, got '%s' instead", data)
+	}
+
+	// nothing was written outside of the output directories
+	if Exists(filepath.Join(basePath, "out", "evil.txt")) {
+		t.Errorf("Expected no file written through a chain of links")
+	}
+
+	// garbage doesn't extract
+	filename := filepath.Join(basePath, "garbage.tar.gz")
+	if err := ioutil.WriteFile(filename, []byte("<html>Not Found</html>"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := extractArchive(filename, basePath); err == nil {
+		t.Errorf("Expected an error extracting garbage")
+	}
+}
+
+func TestArchiveSource(t *testing.T) {
+	InitTestLogging()
+	basePath, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(basePath)
+	if err := writeTestArchive(filepath.Join(basePath, "foo-1.0.tar.gz"), []testArchiveEntry{
+		{Name: "foo-1.0/foo.go", Body: "package foo"},
+	}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(basePath, "bad-1.0.tar.gz"),
+		[]byte("<html>Not Found</html>"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(basePath)))
+	defer server.Close()
+
+	cache := newTestCache(t)
+	defer os.RemoveAll(cache.Root)
+	libsrc := &ArchiveSCM{Cache: cache}
+
+	dep, err := NewDependency("example.com/foo", server.URL+"/foo-1.0.tar.gz", "1.0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if !Exists(filepath.Join(lib.SourceDir, "foo.go")) {
+		t.Errorf("Expected foo.go in %v", lib.SourceDir)
+	}
+
+	// the observed digest makes it into the lock file
+	archiveSum, err := FileHash(filepath.Join(basePath, "foo-1.0.tar.gz"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	digest := strings.TrimPrefix(archiveSum, "sha256:")
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if lib.Sha256 != digest {
+		t.Errorf("Expected sha256 %v, got %v instead", digest, lib.Sha256)
+	} else {
+		buf := &bytes.Buffer{}
+		lib.ToToml(buf)
+		if !strings.Contains(buf.String(), "sha256 = \""+digest+"\"") {
+			t.Errorf("Unexpected lock entry: %v", buf.String())
+		}
+	}
+
+	// declared digests are checked before anything is extracted
+	dep.Sha256 = digest
+	if _, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	}
+	dep.Sha256 = strings.Repeat("0", 64)
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Expected an error for a sha256 mismatch")
+	}
+
+	// bad downloads fail, and aren't kept around
+	dep, err = NewDependency("example.com/bad", server.URL+"/bad-1.0.tar.gz", "1.0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Expected an error for a bad archive")
+	}
+	cache.Offline = true
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Expected an error for a bad archive")
+	} else if _, ok := err.(*NotCachedError); !ok {
+		t.Errorf("Expected the bad archive to be discarded, got %v instead", err)
+	}
+
+	// missing downloads fail
+	cache.Offline = false
+	dep, err = NewDependency("example.com/missing", server.URL+"/missing-1.0.tar.gz", "1.0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := libsrc.Resolve(dep); err == nil {
+		t.Errorf("Expected an error for a missing archive")
+	}
+}
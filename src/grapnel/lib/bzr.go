@@ -0,0 +1,219 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	log "grapnel/log"
+	"regexp"
+	"strings"
+)
+
+var BzrRewriteRules = RewriteRuleArray{
+	// rewrite rules for misc bazaar resolvers
+	TypeResolverRule("bzr-scheme", "scheme", `^bzr(\+ssh)?$`, `bzr`),
+	TypeResolverRule("bzr-path", "path", `.*\.bzr$`, `bzr`),
+	TypeResolverRule("launchpad-import", "import", `launchpad\.net/.*`, `bzr`),
+}
+
+// Bazaar branches.  Each branch has a url of its own, so a dependency's
+// branch is a path relative to its url, like 'trunk'.
+type BzrSCM struct {
+	Cache *Cache
+}
+
+// returns the url of a branch within a repository
+func bzrBranchUrl(repoUrl string, branch string) string {
+	if branch == "" {
+		return repoUrl
+	}
+	return strings.TrimSuffix(repoUrl, "/") + "/" + branch
+}
+
+// Returns the path to a mirror of a bazaar branch.  The branch or pull is
+// killed if cancel is closed.
+func (self *BzrSCM) bzrMirror(branchUrl string, cancel <-chan struct{}) (string, error) {
+	return self.Cache.Mirror("bzr", branchUrl, func(dir string) error {
+		return (&RunContext{WorkingDirectory: dir, Cancel: cancel}).Run(
+			"bzr", "branch", "--no-tree", "--use-existing-dir", branchUrl, dir)
+	}, func(dir string) error {
+		return (&RunContext{WorkingDirectory: dir, Cancel: cancel}).Run(
+			"bzr", "pull", "--overwrite", branchUrl)
+	})
+}
+
+// mirrors the library branch, trying a url built from the import if need be
+func (self *BzrSCM) mirror(lib *Library) (string, error) {
+	return mirrorLibrary(lib, []string{"https", "http", "bzr", "bzr+ssh"},
+		func(repoUrl string) (string, error) {
+			return self.bzrMirror(bzrBranchUrl(repoUrl, lib.Branch), lib.cancel)
+		})
+}
+
+// Revision ids as bzr makes them, 'email-date-random', and as imported
+// from other systems, like 'git-v1:hash'.
+var bzrRevid = regexp.MustCompile(`^\S+-\d{14}-[0-9a-z]{16}$|^[a-z]+-v\d+:\S+$`)
+
+// Turns a tag, revision id or HEAD into a revision spec for 'bzr -r'.  Bzr
+// takes a bare revision id for a tag or revno, so those get the 'revid:'
+// prefix.
+func bzrRevisionSpec(rev string) string {
+	if rev == "HEAD" {
+		return "-1"
+	} else if bzrRevid.MatchString(rev) {
+		return "revid:" + rev
+	}
+	return rev
+}
+
+// finds the revision id that a tag, revno or revision id refers to
+func bzrRevisionId(cmd *RunContext, rev string) (string, error) {
+	if err := cmd.Run("bzr", "revision-info", "-r", rev); err != nil {
+		return "", err
+	}
+	fields := strings.Fields(cmd.CombinedOutput)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("Unexpected output from bzr: '%s'", cmd.CombinedOutput)
+	}
+	return fields[1], nil
+}
+
+// lists the tags in a branch
+func bzrTags(cmd *RunContext) ([]string, error) {
+	revids, err := bzrTagRevisionIds(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return tagNames(revids), nil
+}
+
+// maps the tags in a branch to the revision id each one is on
+func bzrTagRevisionIds(cmd *RunContext) (map[string]string, error) {
+	if err := cmd.Run("bzr", "tags", "--show-ids"); err != nil {
+		return nil, fmt.Errorf("Failed to acquire tag list for dependency")
+	}
+	results := map[string]string{}
+	for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 {
+			results[fields[0]] = fields[1]
+		} else if len(fields) > 0 {
+			results[fields[0]] = ""
+		}
+	}
+	return results, nil
+}
+
+func (self *BzrSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+
+	// fix the tag; there is no default branch
+	if lib.Tag == "" {
+		lib.Tag = "HEAD"
+	}
+
+	log.Info("Fetching Bazaar Dependency: '%s'", lib.Import)
+
+	// bring the mirror up to date, and work from there
+	mirror, err := self.mirror(lib)
+	if err != nil {
+		return nil, err
+	}
+	cmd := lib.runContext(mirror)
+
+	// find the specified revision - may be a tag, revision id or HEAD of the branch
+	revid, err := bzrRevisionId(cmd, bzrRevisionSpec(lib.Tag))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
+	}
+
+	// Pin the Tag to a revision id if we just have "HEAD" as the 'Tag'
+	if lib.Tag == "HEAD" {
+		lib.Tag = revid
+	}
+
+	if picked, err := selectVersion(lib, dep, func() ([]string, error) {
+		return bzrTags(cmd)
+	}); err != nil {
+		return nil, err
+	} else if picked {
+		if revid, err = bzrRevisionId(cmd, "tag:"+lib.Tag); err != nil {
+			return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
+		}
+	}
+
+	// export the source tree, unless it's already in the cache
+	key := CacheKey("bzr", bzrBranchUrl(lib.Url.String(), lib.Branch), revid)
+	lib.SourceDir, err = self.Cache.SourceTree(key, func(dir string) error {
+		return cmd.Run("bzr", "export", "-r", "revid:"+revid, dir)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if lib.Version.Major >= 0 {
+		log.Info("Resolved: %s %v", lib.Import, lib.Version)
+	}
+	return lib, nil
+}
+
+func (self *BzrSCM) ListVersions(dep *Dependency) ([]*TaggedVersion, error) {
+	lib := NewLibrary(dep)
+
+	log.Info("Fetching Bazaar tags: '%s'", lib.Import)
+
+	mirror, err := self.mirror(lib)
+	if err != nil {
+		return nil, err
+	}
+	revids, err := bzrTagRevisionIds(lib.runContext(mirror))
+	if err != nil {
+		return nil, err
+	}
+	versions := dep.TaggedVersions(tagNames(revids))
+	setTagCommits(versions, revids)
+	return versions, nil
+}
+
+func (self *BzrSCM) BranchHead(dep *Dependency) (string, error) {
+	if dep.Url == nil {
+		return "", fmt.Errorf("No url for dependency: '%s'", dep.Import)
+	}
+	branchUrl := bzrBranchUrl(dep.Url.String(), dep.Branch)
+	if self.Cache.Offline {
+		return "", &NotCachedError{Url: branchUrl}
+	}
+
+	cmd := NewRunContext("")
+	if err := cmd.Run("bzr", "revision-info", "-d", branchUrl, "-r", "-1"); err != nil {
+		return "", fmt.Errorf("Cannot identify remote branch: '%s'", branchUrl)
+	}
+	fields := strings.Fields(cmd.CombinedOutput)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("No head for dependency: '%s'", dep.Import)
+	}
+	return fields[1], nil
+}
+
+func (self *BzrSCM) ToDSD(*Library) string {
+	return ""
+}
@@ -0,0 +1,152 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	. "grapnel/testing"
+	url "grapnel/url"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestBzrRewrite(t *testing.T) {
+	rules := RewriteRuleArray{}
+	rules = append(rules, BasicRewriteRules...)
+	rules = append(rules, BzrRewriteRules...)
+
+	for _, test := range []struct {
+		Src *Dependency
+		Dst *Dependency
+	}{
+		{
+			Src: &Dependency{
+				Import: "example.com/foo/bar",
+				Url:    url.MustParse("bzr://example.com/foo/bar"),
+			},
+			Dst: &Dependency{
+				Import: "example.com/foo/bar",
+				Url:    url.MustParse("bzr://example.com/foo/bar"),
+				Type:   "bzr",
+			},
+		}, {
+			Src: &Dependency{
+				Import: "launchpad.net/foo",
+			},
+			Dst: &Dependency{
+				Import: "launchpad.net/foo",
+				Url:    url.MustParse("http://launchpad.net/foo"),
+				Type:   "bzr",
+			},
+		},
+	} {
+		if err := rules.Apply(test.Src); err != nil {
+			t.Errorf("Error during replacement %v; Src: %v", err, test.Src.Flatten())
+		}
+		if !test.Src.Equal(test.Dst) {
+			t.Errorf("Error during replacement Src: %#v; Dst: %#v",
+				test.Src.Flatten(), test.Dst.Flatten())
+		}
+	}
+}
+
+func TestBzrRevisionSpec(t *testing.T) {
+	for _, test := range []struct {
+		Rev  string
+		Spec string
+	}{
+		{"HEAD", "-1"},
+		{"v1.0", "v1.0"},
+		{"42", "42"},
+		{"you@example.com-20140102030405-0123456789abcdef",
+			"revid:you@example.com-20140102030405-0123456789abcdef"},
+		{"git-v1:0123456789abcdef0123456789abcdef01234567",
+			"revid:git-v1:0123456789abcdef0123456789abcdef01234567"},
+	} {
+		if spec := bzrRevisionSpec(test.Rev); spec != test.Spec {
+			t.Errorf("Expected spec %v for %v, got %v instead", test.Spec, test.Rev, spec)
+		}
+	}
+}
+
+func TestBzrSource(t *testing.T) {
+	if _, err := exec.LookPath("bzr"); err != nil {
+		t.Skip("bzr is not installed")
+	}
+	InitTestLogging()
+
+	basePath := BuildTestBzrRepo("bzrrepo")
+	defer os.RemoveAll(basePath)
+	repoUrl := "file://" + filepath.Join(basePath, "bzrrepo")
+
+	cacheRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(cacheRoot)
+	libsrc := &BzrSCM{Cache: NewCache(cacheRoot)}
+
+	// versioned
+	dep, err := NewDependency("foo/bar/baz", repoUrl, "1.0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if lib.Tag != "v1.0" {
+		t.Errorf("Expected tag v1.0, got %v instead", lib.Tag)
+	} else if !Exists(filepath.Join(lib.SourceDir, "README")) {
+		t.Errorf("Expected a source tree in %v", lib.SourceDir)
+	}
+
+	// unversioned deps are pinned to a revision id
+	dep, err = NewDependency("foo/bar/baz", repoUrl, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	head, err := libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if head.Tag == "HEAD" || head.Tag == "" {
+		t.Errorf("Expected a revision id, got %v instead", head.Tag)
+	} else if !Exists(filepath.Join(head.SourceDir, "foo.txt")) {
+		t.Errorf("Expected the head source tree in %v", head.SourceDir)
+	}
+
+	// the locked revision id finds the same revision
+	dep.Tag = head.Tag
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if lib.SourceDir != head.SourceDir {
+		t.Errorf("Expected source tree %v, got %v instead", head.SourceDir, lib.SourceDir)
+	}
+
+	// test the tag listing
+	if versions, err := libsrc.ListVersions(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if len(versions) != 2 {
+		t.Errorf("Expected 2 tagged versions, got %v instead", len(versions))
+	}
+}
@@ -0,0 +1,393 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "grapnel/log"
+	url "grapnel/url"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// Download cache shared by every project on the machine.
+//
+// Each entry is built in a temporary directory next to where it belongs, and
+// moved into place once it is complete.  All work on an entry happens under
+// an exclusive file lock, so concurrent grapnel processes can share a cache.
+//
+//	<vcs>/<key>.<vcs>     mirrors of repositories, such as git/<key>.git, keyed by url
+//	archive/<key>/<file>  downloaded archives, keyed by url
+//	src/<key>             source trees, keyed by url and commit or content hash
+//	lock/<key>            lock files for all of the above
+type Cache struct {
+	Root    string
+	Offline bool // use only what is already cached; never contact a remote
+	lock    sync.Mutex
+	fetched map[string]bool // mirrors already brought up to date by this process
+}
+
+// Reported when working offline, and something is not in the cache.
+type NotCachedError struct {
+	Url string
+}
+
+func (self *NotCachedError) Error() string {
+	return fmt.Sprintf("Not available offline: '%s'", self.Url)
+}
+
+func NewCache(root string) *Cache {
+	return &Cache{
+		Root:    root,
+		fetched: map[string]bool{},
+	}
+}
+
+// Returns the default cache location: ~/.grapnel/cache
+func DefaultCacheRoot() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(usr.HomeDir, ".grapnel", "cache"), nil
+}
+
+// Builds a cache key out of any number of strings.
+func CacheKey(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		io.WriteString(hash, part)
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Returns a content hash for a file, in the form 'sha256:...'
+func FileHash(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Returns the hash of an entry in a tree: the contents of a file, or the
+// text of a symlink, marked so that the two can't be mistaken for each other.
+func entryHash(path string, info os.FileInfo) (string, error) {
+	if info.Mode()&os.ModeSymlink == 0 {
+		return FileHash(path)
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(target))
+	return "symlink:" + hex.EncodeToString(sum[:]), nil
+}
+
+// Returns a content hash for a source tree, in the form 'sha256:...'  The
+// hash covers the relative path and contents of every file in the tree, in
+// lexical order, so it depends only on what would be installed.  Symlinks
+// count by where they point, and are never followed.
+func TreeHash(dir string) (string, error) {
+	return treeHash(dir, nil)
+}
+
+// hashes a tree, leaving out the skipped directories, by relative path
+func treeHash(dir string, skip map[string]bool) (string, error) {
+	hash := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if skip[relativePath] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		fileSum, err := entryHash(path, info)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s  %s\n", fileSum, filepath.ToSlash(relativePath))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// holds an exclusive lock on key while fn runs; the lock is shared with
+// other processes, and other goroutines in this one
+func (self *Cache) withLock(key string, fn func() error) error {
+	lockDir := filepath.Join(self.Root, "lock")
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(lockDir, key), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("Cannot lock cache entry '%s': %v", key, err)
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	return fn()
+}
+
+// fills a temporary directory with fn, and moves it to dest on success
+func (self *Cache) build(dest string, fn func(dir string) error) error {
+	parent := filepath.Dir(dest)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	tempDir, err := ioutil.TempDir(parent, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	if err := fn(tempDir); err != nil {
+		return err
+	}
+	return os.Rename(tempDir, dest)
+}
+
+// Returns the path to a mirror of a repository, kept under a directory for
+// each kind of repository.  The mirror is built with clone if it is new, and
+// brought up to date with fetch once per process otherwise.  Offline, the
+// mirror is used as-is.
+func (self *Cache) Mirror(kind string, repoUrl string, clone func(dir string) error, fetch func(dir string) error) (string, error) {
+	key := CacheKey(kind, repoUrl)
+	mirror := filepath.Join(self.Root, kind, key+"."+kind)
+	err := self.withLock(key, func() error {
+		self.lock.Lock()
+		fetched := self.fetched[mirror]
+		self.lock.Unlock()
+		if fetched {
+			return nil
+		} else if self.Offline {
+			if !Exists(mirror) {
+				return &NotCachedError{Url: repoUrl}
+			}
+			return nil
+		}
+
+		if Exists(mirror) {
+			log.Info("Fetching into mirror: '%s'", repoUrl)
+			if err := fetch(mirror); err != nil {
+				return err
+			}
+		} else {
+			log.Info("Mirroring: '%s'", repoUrl)
+			if err := self.build(mirror, clone); err != nil {
+				return err
+			}
+		}
+
+		self.lock.Lock()
+		self.fetched[mirror] = true
+		self.lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return mirror, nil
+}
+
+// Mirrors a library's repository, trying urls built from the import with
+// each of the protocols if the library has no url.  The url that worked is
+// pinned to the library.
+func mirrorLibrary(lib *Library, protocols []string, mirror func(repoUrl string) (string, error)) (string, error) {
+	if lib.Url != nil {
+		path, err := mirror(lib.Url.String())
+		if _, ok := err.(*NotCachedError); ok {
+			return "", err
+		} else if err != nil {
+			return "", fmt.Errorf("Cannot download dependency: '%s'", lib.Url.String())
+		}
+		return path, nil
+	}
+
+	// try all supported protocols against a URL composed from the import
+	var err error
+	for _, protocol := range protocols {
+		packageUrl := protocol + "://" + lib.Import
+		log.Warn("Synthesizing url from import: '%s'", packageUrl)
+		var path string
+		if path, err = mirror(packageUrl); err != nil {
+			log.Warn("Failed to fetch: '%s'", packageUrl)
+			continue
+		}
+		lib.Url, _ = url.Parse(packageUrl) // pin URL
+		return path, nil
+	}
+	if _, ok := err.(*NotCachedError); ok {
+		return "", &NotCachedError{Url: lib.Import}
+	}
+	return "", fmt.Errorf("Cannot download dependency: '%s'", lib.Import)
+}
+
+// Returns the path to a bare mirror of a git repository.  The clone or update
+// is killed if cancel is closed.
+func (self *Cache) GitMirror(repoUrl string, cancel <-chan struct{}) (string, error) {
+	return self.Mirror("git", repoUrl, func(dir string) error {
+		return (&RunContext{WorkingDirectory: dir, Cancel: cancel}).Run(
+			"git", "clone", "--mirror", repoUrl, dir)
+	}, func(dir string) error {
+		return (&RunContext{WorkingDirectory: dir, Cancel: cancel}).Run(
+			"git", "remote", "update", "--prune")
+	})
+}
+
+// Returns the path to a listing of the heads and tags in a remote git
+// repository, as reported by 'git ls-remote'.  The listing is refreshed once
+// per process, and used as-is when offline.  The listing is killed if cancel
+// is closed.
+func (self *Cache) GitRefs(repoUrl string, cancel <-chan struct{}) (string, error) {
+	key := CacheKey("git-refs", repoUrl)
+	filename := filepath.Join(self.Root, "git", key+".refs")
+	err := self.withLock(key, func() error {
+		self.lock.Lock()
+		fetched := self.fetched[filename]
+		self.lock.Unlock()
+		if fetched {
+			return nil
+		} else if self.Offline {
+			if !Exists(filename) {
+				return &NotCachedError{Url: repoUrl}
+			}
+			return nil
+		}
+
+		log.Info("Listing remote refs: '%s'", repoUrl)
+		cmd := &RunContext{Cancel: cancel}
+		if err := cmd.Run("git", "ls-remote", "--heads", "--tags", repoUrl); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		tempFile := filename + ".tmp"
+		if err := ioutil.WriteFile(tempFile, []byte(cmd.CombinedOutput), 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tempFile, filename); err != nil {
+			return err
+		}
+
+		self.lock.Lock()
+		self.fetched[filename] = true
+		self.lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// Returns the path to a downloaded copy of an archive.  Archives are
+// downloaded only once, since the file at a versioned url is not expected
+// to change.
+func (self *Cache) Download(archiveUrl *url.URL) (string, error) {
+	key := CacheKey("archive", archiveUrl.String())
+	filename := filepath.Join(self.Root, "archive", key, path.Base(archiveUrl.Path))
+	err := self.withLock(key, func() error {
+		if Exists(filename) {
+			return nil
+		} else if self.Offline {
+			return &NotCachedError{Url: archiveUrl.String()}
+		}
+		log.Info("Downloading: '%s'", archiveUrl.String())
+		return self.build(filepath.Dir(filename), func(dir string) error {
+			response, err := http.Get(archiveUrl.String())
+			if err != nil {
+				return fmt.Errorf("Cannot download archive: %v", err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != http.StatusOK {
+				return fmt.Errorf("Cannot download archive: %s", response.Status)
+			}
+			file, err := os.Create(filepath.Join(dir, filepath.Base(filename)))
+			if err != nil {
+				return fmt.Errorf("Cannot open archive for writing: %v", err)
+			}
+			defer file.Close()
+			if _, err := io.Copy(file, response.Body); err != nil {
+				return fmt.Errorf("Cannot write archive: %v", err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// Removes a downloaded archive from the cache, so that it is downloaded
+// again the next time it is needed.
+func (self *Cache) DiscardDownload(archiveUrl *url.URL) error {
+	key := CacheKey("archive", archiveUrl.String())
+	return self.withLock(key, func() error {
+		return os.RemoveAll(filepath.Join(self.Root, "archive", key))
+	})
+}
+
+// Returns the path to a source tree, which fn fills in if it is not already
+// in the cache.  Source trees must not be modified once they are built.
+func (self *Cache) SourceTree(key string, fn func(dir string) error) (string, error) {
+	tree := filepath.Join(self.Root, "src", key)
+	err := self.withLock(key, func() error {
+		if Exists(tree) {
+			return nil
+		}
+		return self.build(tree, fn)
+	})
+	if err != nil {
+		return "", err
+	}
+	return tree, nil
+}
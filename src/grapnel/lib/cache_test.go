@@ -0,0 +1,256 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	. "grapnel/testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestCache(t *testing.T) *Cache {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return NewCache(root)
+}
+
+func TestCacheSourceTree(t *testing.T) {
+	InitTestLogging()
+	cache := newTestCache(t)
+	defer os.RemoveAll(cache.Root)
+
+	// many callers, one build
+	var builds int32
+	trees := make([]string, 8)
+	var wg sync.WaitGroup
+	for ii := range trees {
+		wg.Add(1)
+		go func(ii int) {
+			defer wg.Done()
+			tree, err := cache.SourceTree(CacheKey("test"), func(dir string) error {
+				atomic.AddInt32(&builds, 1)
+				return ioutil.WriteFile(filepath.Join(dir, "file.go"), []byte("package x"), 0644)
+			})
+			if err != nil {
+				t.Errorf("%v", err)
+			}
+			trees[ii] = tree
+		}(ii)
+	}
+	wg.Wait()
+
+	if builds != 1 {
+		t.Errorf("Expected 1 build, got %v instead", builds)
+	}
+	for _, tree := range trees {
+		if tree != trees[0] || !Exists(filepath.Join(tree, "file.go")) {
+			t.Errorf("Unexpected source tree: %v", tree)
+		}
+	}
+}
+
+func TestCacheGitMirror(t *testing.T) {
+	InitTestLogging()
+	cache := newTestCache(t)
+	defer os.RemoveAll(cache.Root)
+
+	basePath := BuildTestGitRepo("gitrepo")
+	defer os.RemoveAll(basePath)
+	repoPath := filepath.Join(basePath, "gitrepo")
+
+	mirror, err := cache.GitMirror(repoPath, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := gitCommit(NewRunContext(mirror), "v1.1"); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	// another process picks up new tags in the same mirror
+	NewRunContext(repoPath).MustRun("git", "tag", "v1.2")
+	if mirror, err = NewCache(cache.Root).GitMirror(repoPath, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := gitCommit(NewRunContext(mirror), "v1.2"); err != nil {
+		t.Errorf("Expected mirror to be fetched: %v", err)
+	}
+}
+
+func TestCacheGitRefs(t *testing.T) {
+	InitTestLogging()
+	cache := newTestCache(t)
+	defer os.RemoveAll(cache.Root)
+
+	basePath := BuildTestGitRepo("gitrepo")
+	defer os.RemoveAll(basePath)
+	repoPath := filepath.Join(basePath, "gitrepo")
+
+	filename, err := cache.GitRefs(repoPath, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	refs, err := loadGitRefs(filename)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if tags := refs.tags(); len(tags) != 2 || tags[0] != "v1.0" || tags[1] != "v1.1" {
+		t.Errorf("Expected tags v1.0 and v1.1, got %v instead", tags)
+	}
+	if _, ok := refs["refs/heads/master"]; !ok {
+		t.Errorf("Expected a master branch, got %v instead", refs)
+	}
+
+	// offline, the last listing is used as-is
+	NewRunContext(repoPath).MustRun("git", "tag", "v1.2")
+	offline := NewCache(cache.Root)
+	offline.Offline = true
+	if filename, err = offline.GitRefs(repoPath, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if refs, err = loadGitRefs(filename); err != nil {
+		t.Fatalf("%v", err)
+	} else if len(refs.tags()) != 2 {
+		t.Errorf("Expected 2 tags offline, got %v instead", refs.tags())
+	}
+
+	// and another process picks up the new tag
+	if filename, err = NewCache(cache.Root).GitRefs(repoPath, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if refs, err = loadGitRefs(filename); err != nil {
+		t.Fatalf("%v", err)
+	} else if len(refs.tags()) != 3 {
+		t.Errorf("Expected 3 tags, got %v instead", refs.tags())
+	}
+}
+
+func TestCacheOffline(t *testing.T) {
+	InitTestLogging()
+	cache := newTestCache(t)
+	defer os.RemoveAll(cache.Root)
+
+	basePath := BuildTestGitRepo("gitrepo")
+	defer os.RemoveAll(basePath)
+	repoPath := filepath.Join(basePath, "gitrepo")
+
+	// nothing is cached yet
+	cache.Offline = true
+	if _, err := cache.GitMirror(repoPath, nil); err == nil {
+		t.Errorf("Expected an error for an uncached mirror")
+	} else if _, ok := err.(*NotCachedError); !ok {
+		t.Errorf("Expected a not-cached error, got %v instead", err)
+	}
+
+	// mirror it, then work from the mirror without fetching
+	cache.Offline = false
+	if _, err := cache.GitMirror(repoPath, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	offline := NewCache(cache.Root)
+	offline.Offline = true
+	os.RemoveAll(repoPath)
+	if _, err := offline.GitMirror(repoPath, nil); err != nil {
+		t.Errorf("Expected the cached mirror offline, got %v instead", err)
+	}
+}
+
+func TestTreeHash(t *testing.T) {
+	InitTestLogging()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hashOf := func() string {
+		sum, err := TreeHash(dir)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return sum
+	}
+
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "b.go"), []byte("package b"), 0644)
+	original := hashOf()
+	if original != hashOf() {
+		t.Errorf("Expected the same hash twice")
+	}
+
+	// contents and names both count
+	ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package a // changed"), 0644)
+	changed := hashOf()
+	if changed == original {
+		t.Errorf("Expected a different hash for different contents")
+	}
+	os.Rename(filepath.Join(dir, "a.go"), filepath.Join(dir, "c.go"))
+	if hashOf() == changed {
+		t.Errorf("Expected a different hash for a different file name")
+	}
+}
+
+func TestTreeHashSymlinks(t *testing.T) {
+	InitTestLogging()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "sub", "a.go"), []byte("package a"), 0644)
+	os.Symlink("sub", filepath.Join(src, "dirlink"))
+	os.Symlink("sub/a.go", filepath.Join(src, "filelink"))
+
+	// links to directories hash fine, by where they point
+	original, err := TreeHash(src)
+	if err != nil {
+		t.Fatalf("Error hashing tree with symlinks: %v", err)
+	}
+	os.Remove(filepath.Join(src, "filelink"))
+	ioutil.WriteFile(filepath.Join(src, "filelink"), []byte("package a"), 0644)
+	if sum, _ := TreeHash(src); sum == original {
+		t.Errorf("Expected a file and a link to the same contents to hash differently")
+	}
+	os.Remove(filepath.Join(src, "filelink"))
+	os.Symlink("sub/a.go", filepath.Join(src, "filelink"))
+
+	// links are copied as links, and the copy hashes the same
+	dest := filepath.Join(dir, "dest")
+	if err := CopyFileTree(dest, src); err != nil {
+		t.Fatalf("Error copying tree with symlinks: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "dirlink")); err != nil || target != "sub" {
+		t.Errorf("Expected a link to 'sub', got '%s' (%v)", target, err)
+	}
+	if sum, err := TreeHash(dest); err != nil || sum != original {
+		t.Errorf("Expected the copy to hash the same: %v", err)
+	}
+}
@@ -26,16 +26,27 @@ import (
 	"fmt"
 	toml "github.com/pelletier/go-toml"
 	url "grapnel/url"
+	"path/filepath"
+	"strings"
 )
 
 type Dependency struct {
-	//TODO: parent dependency for error reporting
-	Import      string
-	Url         *url.URL
-	Type        string
-	Branch      string
-	Tag         string // alased to: commit and revision
-	VersionSpec *VersionSpec
+	Parent        *Library // library that introduced this dependency; nil for the root package
+	Import        string
+	Url           *url.URL
+	Type          string
+	Branch        string
+	Tag           string // alased to: commit and revision
+	VersionSpec   *VersionSpec
+	Sum           string // hash of the source tree, as recorded in a lock file
+	Path          string // directory on the local filesystem, for 'path' dependencies
+	Sha256        string // hex digest of a downloaded archive
+	VersionScheme string // name of the scheme for reading versions from tags
+
+	Original *Dependency // the dependency as requested, before any rewrite rules
+	Rewrites []string    // names of the rewrite rules that changed it
+
+	cancel <-chan struct{} // closed once the dependency is no longer wanted
 }
 
 func NewDependency(importStr string, urlStr string, versionStr string) (*Dependency, error) {
@@ -148,8 +159,95 @@ func (self *Dependency) Reconcile(other *Dependency) (*Dependency, error) {
 		return self, nil
 	} else if other.VersionSpec.Outranks(self.VersionSpec) {
 		return other, nil
+	} else if spec := self.VersionSpec.Intersect(other.VersionSpec); spec != nil {
+		// neither is narrower; settle on the versions both will take
+		result := *self
+		result.VersionSpec = spec
+		return &result, nil
+	}
+	return nil, &ConflictError{
+		Import: self.Import,
+		Deps:   []*Dependency{self, other},
+	}
+}
+
+// Keeps a copy of the dependency as it stands, so the lock file can show
+// what was asked for once the rewrite rules are done with it.
+func (self *Dependency) keepOriginal() {
+	if self.Original != nil {
+		return
+	}
+	self.Original = self.Copy()
+}
+
+// Returns a copy of the dependency that can be changed, url included,
+// without touching this one.
+func (self *Dependency) Copy() *Dependency {
+	result := *self
+	if self.Url != nil {
+		resultUrl := *self.Url
+		result.Url = &resultUrl
+	}
+	return &result
+}
+
+// Returns a context for running commands on behalf of the dependency.  They
+// are killed once the dependency is no longer wanted.
+func (self *Dependency) runContext(workingDirectory string) *RunContext {
+	return &RunContext{
+		WorkingDirectory: workingDirectory,
+		Cancel:           self.cancel,
+	}
+}
+
+// The scheme that reads versions out of the dependency's tags.
+func (self *Dependency) scheme() VersionScheme {
+	if scheme, err := FindVersionScheme(self.VersionScheme); err == nil {
+		return scheme
+	}
+	return VersionSchemes["semver"]
+}
+
+// Reads the version out of a tag, per the dependency's version scheme.
+func (self *Dependency) ParseTag(tag string) (*Version, error) {
+	return self.scheme().ParseVersion(tag)
+}
+
+// Reads versions out of tags, per the dependency's version scheme, newest first.
+func (self *Dependency) TaggedVersions(tags []string) []*TaggedVersion {
+	return TaggedVersions(self.scheme(), tags)
+}
+
+// True if the dependency is taken from the local filesystem, and so cannot
+// be reproduced elsewhere.
+func (self *Dependency) IsLocal() bool {
+	return self.Type == "path"
+}
+
+// Describes what the dependency asks for.
+func (self *Dependency) Constraint() string {
+	if self.VersionSpec != nil && !self.VersionSpec.IsUnversioned() {
+		return self.VersionSpec.String()
+	} else if self.IsLocal() {
+		return "local"
+	} else if self.Tag != "" {
+		return "tag " + self.Tag
+	} else if self.Branch != "" {
+		return "branch " + self.Branch
+	}
+	return "unversioned"
+}
+
+// Describes the chain of libraries that introduced this dependency, starting
+// at the root package file.
+func (self *Dependency) PathString() string {
+	steps := []string{fmt.Sprintf("%s (%s)", self.Import, self.Constraint())}
+	for parent := self.Parent; parent != nil; parent = parent.Parent {
+		step := fmt.Sprintf("%s (%s, picked %s)",
+			parent.Import, parent.Constraint(), parent.Selection())
+		steps = append([]string{step}, steps...)
 	}
-	return nil, fmt.Errorf("Cannot reconcile dependencies for '%v'", self.Import)
+	return "root -> " + strings.Join(steps, " -> ")
 }
 
 func (self *Dependency) Equal(other *Dependency) bool {
@@ -182,6 +280,33 @@ func NewDependencyFromToml(tree *toml.TomlTree) (*Dependency, error) {
 	dep.Type = tree.GetDefault("type", "").(string)
 	dep.Branch = tree.GetDefault("branch", "").(string)
 	dep.Tag = tree.GetDefault("tag", "").(string)
+	dep.Sum = tree.GetDefault("sum", "").(string)
+	dep.Path = tree.GetDefault("path", "").(string)
+	dep.Sha256 = strings.ToLower(strings.TrimPrefix(
+		tree.GetDefault("sha256", "").(string), "sha256:"))
+	if dep.Path != "" && dep.Type == "" {
+		dep.Type = "path"
+	}
+	dep.VersionScheme = tree.GetDefault("scheme", "").(string)
+	if _, err := FindVersionScheme(dep.VersionScheme); err != nil {
+		return nil, err
+	}
+
+	// lock file entries keep track of any rewrite rules that changed them
+	if rewrites, ok := tree.Get("rewrites").([]interface{}); ok {
+		for _, rewrite := range rewrites {
+			name, ok := rewrite.(string)
+			if !ok {
+				return nil, fmt.Errorf("Rewrite rule names must be strings: '%v'", rewrite)
+			}
+			dep.Rewrites = append(dep.Rewrites, name)
+		}
+	}
+	if requested, ok := tree.Get("requested").(*toml.TomlTree); ok {
+		if dep.Original, err = NewDependencyFromToml(requested); err != nil {
+			return nil, fmt.Errorf("In requested dependency: %v", err)
+		}
+	}
 
 	return dep, nil
 }
@@ -202,6 +327,10 @@ func loadDependencies(filename string) ([]*Dependency, error) {
 		if dep, err := NewDependencyFromToml(item); err != nil {
 			return nil, fmt.Errorf("In dependency #%d: %v", idx, err)
 		} else {
+			// local paths are relative to the file that cites them
+			if dep.Path != "" && !filepath.IsAbs(dep.Path) {
+				dep.Path = filepath.Join(filepath.Dir(filename), dep.Path)
+			}
 			deplist = append(deplist, dep)
 		}
 	}
@@ -209,6 +338,64 @@ func loadDependencies(filename string) ([]*Dependency, error) {
 	return deplist, nil
 }
 
+// Removes the '[[dependencies]]' entry for importPath from the text of a
+// package file, along with any comments directly above it that are set apart
+// from whatever comes before by a blank line.  The rest of the text is left
+// as-is, including a header comment at the top of the file.
+func RemoveDependencyEntry(content string, importPath string) (string, error) {
+	lines := strings.Split(content, "\n")
+
+	// find the start of each table, including the comments that lead into it
+	starts := []int{}
+	for ii, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "[") {
+			start := ii
+			for start > 0 && strings.HasPrefix(strings.TrimSpace(lines[start-1]), "#") {
+				start--
+			}
+			if start == 0 || strings.TrimSpace(lines[start-1]) != "" {
+				start = ii // the comments belong to the file, or what comes before
+			}
+			starts = append(starts, start)
+		}
+	}
+	starts = append(starts, len(lines))
+
+	// drop every entry for the import, in case it is listed more than once
+	remaining := append([]string{}, lines[:starts[0]]...)
+	found := false
+	for ii := 0; ii < len(starts)-1; ii++ {
+		section := lines[starts[ii]:starts[ii+1]]
+		if isDependencyEntry(section, importPath) {
+			found = true
+		} else {
+			remaining = append(remaining, section...)
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("No dependency entry for '%s'", importPath)
+	}
+	result := strings.Join(remaining, "\n")
+	if strings.HasSuffix(content, "\n") && !strings.HasSuffix(result, "\n") {
+		result += "\n" // the last entry took the final newline with it
+	}
+	return result, nil
+}
+
+// true if the lines hold a single dependency entry for the import
+func isDependencyEntry(section []string, importPath string) bool {
+	tree, err := toml.Load(strings.Join(section, "\n"))
+	if err != nil {
+		return false // not something we can parse alone
+	}
+	items, ok := tree.Get("dependencies").([]*toml.TomlTree)
+	if !ok || len(items) != 1 {
+		return false
+	}
+	dep, err := NewDependencyFromToml(items[0])
+	return err == nil && dep.Import == importPath
+}
+
 func LoadGrapnelDepsfile(searchFiles ...string) ([]*Dependency, error) {
 	for _, filename := range searchFiles {
 		if Exists(filename) {
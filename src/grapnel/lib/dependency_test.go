@@ -35,6 +35,7 @@ type = "test"
 branch = "master"
 tag = "release-1.0"
 version = "1.0.*"
+sha256 = "sha256:ABC123"
 `
 
 func TestNewDepdendency(t *testing.T) {
@@ -93,4 +94,143 @@ func TestNewDependencyFromToml(t *testing.T) {
 		t.Errorf("Bad value for version: '%v'. Expected: '%v'",
 			dep.VersionSpec.String(), "1.0.*")
 	}
+	if dep.Sha256 != "abc123" {
+		t.Errorf("Bad value for sha256: '%v'. Expected: '%v'", dep.Sha256, "abc123")
+	}
+}
+
+var testPackageFile = `# my grapnel file
+[package]
+version = "0.1"
+
+[[dependencies]]
+import = "github.com/foo/bar"
+
+# pinned until the next release
+[[dependencies]]
+import = "github.com/foo/baz"
+version = "1.2"
+
+[[dependencies]]
+url = "http://github.com/foo/gorf"
+`
+
+func TestDependencyVersionScheme(t *testing.T) {
+	tree, _ := toml.Load(`
+import = "gopkg.in/mgo.v2"
+version = "2015.01.24"
+scheme = "calver"
+`)
+	dep, err := NewDependencyFromToml(tree)
+	if err != nil {
+		t.Fatalf("Error loading dependency: %v", err)
+	}
+	if dep.VersionScheme != "calver" {
+		t.Errorf("Bad value for VersionScheme: '%v'", dep.VersionScheme)
+	}
+	best := NewestVersion(dep.TaggedVersions([]string{"r2014.10.12", "r2015.01.24", "r2015.06.03"}),
+		dep.VersionSpec)
+	if best == nil || best.Tag != "r2015.01.24" {
+		t.Errorf("Expected 'r2015.01.24' to be picked; got %v", best)
+	}
+
+	tree, _ = toml.Load(`
+import = "foo/bar"
+scheme = "bogus"
+`)
+	if _, err := NewDependencyFromToml(tree); err == nil {
+		t.Errorf("Expected an error for an unknown version scheme")
+	}
+}
+
+func TestRemoveDependencyEntry(t *testing.T) {
+	result, err := RemoveDependencyEntry(testPackageFile, "github.com/foo/baz")
+	if err != nil {
+		t.Fatalf("Error removing entry: %v", err)
+	}
+	expected := `# my grapnel file
+[package]
+version = "0.1"
+
+[[dependencies]]
+import = "github.com/foo/bar"
+
+[[dependencies]]
+url = "http://github.com/foo/gorf"
+`
+	if result != expected {
+		t.Errorf("Bad result for removal:\n%v", result)
+	}
+
+	if _, err := RemoveDependencyEntry(testPackageFile, "github.com/foo/missing"); err == nil {
+		t.Errorf("Expected an error removing a missing entry")
+	}
+
+	// an import listed twice goes away entirely
+	twice := testPackageFile + "\n[[dependencies]]\nimport = \"github.com/foo/baz\"\n"
+	if result, err := RemoveDependencyEntry(twice, "github.com/foo/baz"); err != nil {
+		t.Errorf("Error removing entry: %v", err)
+	} else if result != expected {
+		t.Errorf("Bad result for removal:\n%v", result)
+	}
+
+	// comments only go along when they're set apart from what comes before
+	for _, test := range []struct {
+		Content  string
+		Expected string
+	}{
+		{"# header\n[[dependencies]]\nimport = \"a\"\n\n[[dependencies]]\nimport = \"b\"\n",
+			"# header\n[[dependencies]]\nimport = \"b\"\n"},
+		{"[[dependencies]]\nimport = \"b\"\n# trailing note\n[[dependencies]]\nimport = \"a\"\n",
+			"[[dependencies]]\nimport = \"b\"\n# trailing note\n"},
+	} {
+		if result, err := RemoveDependencyEntry(test.Content, "a"); err != nil {
+			t.Errorf("Error removing entry: %v", err)
+		} else if result != test.Expected {
+			t.Errorf("Bad result for removal:\n%v", result)
+		}
+	}
+}
+
+func TestDependencyReconcile(t *testing.T) {
+	depA, _ := NewDependency("foo/bar", "", "^1.2")
+	depB, _ := NewDependency("foo/bar", "", "~1.4")
+	depC, _ := NewDependency("foo/bar", "", ">=1.3, <2.5")
+	depD, _ := NewDependency("foo/bar", "", "^2.0")
+
+	// the narrower of the two wins outright
+	if result, err := depA.Reconcile(depB); err != nil {
+		t.Errorf("%v", err)
+	} else if result != depB {
+		t.Errorf("Expected '%v' to win; got '%v'", depB.VersionSpec, result.VersionSpec)
+	}
+
+	// overlapping ranges meet in the middle
+	if result, err := depA.Reconcile(depC); err != nil {
+		t.Errorf("%v", err)
+	} else if result.VersionSpec.String() != ">= 1.3.0, < 2.0.0" {
+		t.Errorf("Bad reconciled version spec: '%v'", result.VersionSpec)
+	} else if !result.VersionSpec.IsSatisfiedBy(NewVersion(1, 9, 0)) ||
+		result.VersionSpec.IsSatisfiedBy(NewVersion(2, 1, 0)) {
+		t.Errorf("Bad range for reconciled version spec: '%v'", result.VersionSpec)
+	}
+
+	// disjoint ranges conflict
+	if _, err := depA.Reconcile(depD); err == nil {
+		t.Errorf("Expected a conflict between '%v' and '%v'", depA.VersionSpec, depD.VersionSpec)
+	} else if _, ok := err.(*ConflictError); !ok {
+		t.Errorf("Expected a ConflictError; got %v", err)
+	}
+}
+
+func TestDependencyCopy(t *testing.T) {
+	dep, _ := NewDependency("foo/bar", "http://example.com/foo/bar", "1.0")
+	result := dep.Copy()
+	result.SetValues(map[string]string{"host": "mirror.example.com", "type": "git"})
+	if dep.Url.Host != "example.com" || dep.Type != "" {
+		t.Errorf("Expected the original to stay as it was: %v", dep.Flatten())
+	}
+	if result.Url.Host != "mirror.example.com" || result.VersionSpec != dep.VersionSpec {
+		t.Errorf("Bad copy: %v", result.Flatten())
+	}
 }
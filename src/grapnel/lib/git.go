@@ -25,22 +25,23 @@ THE SOFTWARE.
 import (
 	"fmt"
 	log "grapnel/log"
-	url "grapnel/url"
 	"io/ioutil"
 	"os"
 	"path"
+	"regexp"
+	"sort"
 	"strings"
 )
 
 var GitRewriteRules = RewriteRuleArray{
 	// rewrite rules for misc git resolvers
-	TypeResolverRule("scheme", `git`, `git`),
-	TypeResolverRule("path", `.*\.git`, `git`),
-	TypeResolverRule("import", `github.com/.*`, `git`),
-	TypeResolverRule("host", `github.com`, `git`),
+	TypeResolverRule("git-scheme", "scheme", `git`, `git`),
+	TypeResolverRule("git-path", "path", `.*\.git`, `git`),
+	TypeResolverRule("github-import", "import", `github.com/.*`, `git`),
+	TypeResolverRule("github-host", "host", `github.com`, `git`),
 
 	// basic gopkg.in imports
-	BuildRewriteRule(StringMap{
+	BuildRewriteRule("gopkg.in", StringMap{
 		"host": `gopkg\.in`,
 		"path": `^/[^/]+$`,
 	}, StringMap{
@@ -50,7 +51,7 @@ var GitRewriteRules = RewriteRuleArray{
 		"type":   `git`,
 	}),
 	// versioned gopkg.in imports
-	BuildRewriteRule(StringMap{
+	BuildRewriteRule("gopkg.in-versioned", StringMap{
 		"host": `gopkg\.in`,
 		"path": `^.+/.+$`,
 	}, StringMap{
@@ -60,7 +61,7 @@ var GitRewriteRules = RewriteRuleArray{
 		"type":   `git`,
 	}),
 	// support for golang.org/x
-	BuildRewriteRule(StringMap{
+	BuildRewriteRule("golang.org/x", StringMap{
 		"host": `golang.org`,
 		"path": `^/x.*$`,
 	}, StringMap{
@@ -70,14 +71,124 @@ var GitRewriteRules = RewriteRuleArray{
 		"type":   `git`,
 	}),
 	// ensure that only the user/project portion of the repo is used when calling git
-	BuildRewriteRule(StringMap{
+	BuildRewriteRule("git-repo-path", StringMap{
 		"type": `git`,
 	}, StringMap{
 		"path": `{{ replace .path "^/([^/]*)/([^/]*)/(.*)$" "/$1/$2" }}`,
 	}),
 }
 
-type GitSCM struct{}
+type GitSCM struct {
+	Cache *Cache
+}
+
+// lists the refs in the library repository, trying a url built from the import if need be
+func (self *GitSCM) refs(lib *Library) (gitRefs, error) {
+	filename, err := mirrorLibrary(lib, []string{"http", "https", "git", "ssh"},
+		func(repoUrl string) (string, error) {
+			return self.Cache.GitRefs(repoUrl, lib.cancel)
+		})
+	if err != nil {
+		return nil, err
+	}
+	return loadGitRefs(filename)
+}
+
+var gitHash = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// commits in a remote repository, by ref name
+type gitRefs map[string]string
+
+// loads a listing from 'git ls-remote'; annotated tags map to their commits
+func loadGitRefs(filename string) (gitRefs, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	refs := gitRefs{}
+	peeled := gitRefs{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !gitHash.MatchString(fields[0]) {
+			continue // not a ref
+		}
+		if name := strings.TrimSuffix(fields[1], "^{}"); name != fields[1] {
+			peeled[name] = fields[0]
+		} else {
+			refs[name] = fields[0]
+		}
+	}
+	for name, commit := range peeled {
+		refs[name] = commit
+	}
+	return refs, nil
+}
+
+// lists the tag names
+func (self gitRefs) tags() []string {
+	results := []string{}
+	for name := range self {
+		if strings.HasPrefix(name, "refs/tags/") {
+			results = append(results, strings.TrimPrefix(name, "refs/tags/"))
+		}
+	}
+	sort.Strings(results)
+	return results
+}
+
+// Finds the commit that a tag, branch or hash refers to, along with the ref
+// that points at it, if any.  Abbreviated hashes need the whole history, so
+// they are looked up in a mirror.
+func (self *GitSCM) findCommit(lib *Library, refs gitRefs, ref string) (string, string, error) {
+	for _, name := range []string{ref, "refs/tags/" + ref, "refs/heads/" + ref} {
+		if commit, ok := refs[name]; ok {
+			return commit, name, nil
+		}
+	}
+	if gitHash.MatchString(ref) {
+		return ref, "", nil
+	}
+	mirror, err := self.Cache.GitMirror(lib.Url.String(), lib.cancel)
+	if err != nil {
+		return "", "", err
+	}
+	commit, err := gitCommit(lib.runContext(mirror), ref)
+	return commit, "", err
+}
+
+// finds the commit that a tag, branch or hash refers to
+func gitCommit(cmd *RunContext, ref string) (string, error) {
+	if err := cmd.Run("git", "rev-parse", "--verify", ref+"^{commit}"); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(cmd.CombinedOutput), nil
+}
+
+// Fetches a single commit into the working directory of cmd, without any git
+// metadata.  The commit is
+// fetched on its own through the ref that points at it, or by hash, and only
+// if the remote won't allow either is the whole history fetched.
+func gitFetchCommit(cmd *RunContext, repoUrl string, commit string, ref string) error {
+	dir := cmd.WorkingDirectory
+	if err := cmd.Run("git", "init", "--quiet"); err != nil {
+		return err
+	}
+	if ref != "" {
+		if err := cmd.Run("git", "fetch", "--depth", "1", repoUrl, ref); err != nil {
+			return err
+		}
+	} else if err := cmd.Run("git", "fetch", "--depth", "1", repoUrl, commit); err != nil {
+		log.Info("Fetching all of '%s' for commit %s", repoUrl, commit)
+		if err := cmd.Run("git", "fetch", repoUrl, "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+			return err
+		}
+	}
+	if err := cmd.Run("git", "-c", "advice.detachedHead=false", "checkout", "--quiet", commit); err != nil {
+		return fmt.Errorf("Failed to checkout commit: '%s'", commit)
+	}
+	stripGitRepo(dir)
+	return nil
+}
 
 func stripGitRepo(baseDir string) {
 	os.RemoveAll(path.Join(baseDir, ".git"))
@@ -96,92 +207,105 @@ func (self *GitSCM) Resolve(dep *Dependency) (*Library, error) {
 
 	log.Info("Fetching Git Dependency: '%s'", lib.Import)
 
-	// create a dedicated directory and a context for commands
-	tempRoot, err := ioutil.TempDir("", "")
-	if err != nil {
-		return nil, err
+	// find the specified commit - may be a tag, commit hash or HEAD of the branch
+	ref := lib.Tag
+	if ref == "HEAD" {
+		ref = "refs/heads/" + lib.Branch
 	}
-	lib.TempDir = tempRoot
-	cmd := NewRunContext(tempRoot)
-
-	// use the configured url and acquire the depified branch
-	log.Info("Fetching remote data for %s", lib.Import)
-	if lib.Url == nil {
-		// try all supported protocols against a URL composed from the import
-		for _, protocol := range []string{"http", "https", "git", "ssh"} {
-			packageUrl := protocol + "://" + lib.Import
-			log.Warn("Synthesizing url from import: '%s'", packageUrl)
-			if err := cmd.Run("git", "clone", packageUrl, "-b", lib.Branch, tempRoot); err != nil {
-				log.Warn("Failed to fetch: '%s'", packageUrl)
-				continue
-			}
-			lib.Url, _ = url.Parse(packageUrl) // pin URL
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("Cannot download dependency: '%s'", lib.Import)
+	var refs gitRefs
+	var commit, fetchRef string
+	var err error
+	if lib.Url != nil && gitHash.MatchString(ref) {
+		commit = ref // nothing to look up
+	} else if refs, err = self.refs(lib); err != nil {
+		return nil, err
+	} else if commit, fetchRef, err = self.findCommit(lib, refs, ref); err != nil {
+		if _, ok := err.(*NotCachedError); ok {
+			return nil, err
 		}
-	} else if err := cmd.Run("git", "clone", lib.Url.String(), "-b", lib.Branch, tempRoot); err != nil {
-		return nil, fmt.Errorf("Cannot download dependency: '%s'", lib.Url.String())
-	}
-
-	// move to the specified commit/tag/hash
-	// check out a depific commit - may be a tag, commit hash or HEAD
-	if err := cmd.Run("git", "checkout", lib.Tag); err != nil {
 		return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
 	}
 
 	// Pin the Tag to a commit hash if we just have "HEAD" as the 'Tag'
 	if lib.Tag == "HEAD" {
-		if err := cmd.Run("git", "rev-list", "--all", "--max-count=1"); err != nil {
-			return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
-		} else {
-			lib.Tag = strings.TrimSpace(cmd.CombinedOutput)
-		}
+		lib.Tag = commit
 	}
 
-	// Stop now if we have no semantic version information
-	if lib.VersionSpec.IsUnversioned() {
-		lib.Version = NewVersion(-1, -1, -1)
-		log.Warn("Resolved: %v (unversioned)", lib.Import)
-		stripGitRepo(lib.TempDir)
-		return lib, nil
-	}
-
-	// find latest version match
-	if err := cmd.Run("git", "for-each-ref", "refs/tags", "--sort=taggerdate",
-		"--format=%(refname:short)"); err != nil {
-		return nil, fmt.Errorf("Failed to acquire ref list for depenency")
-	} else {
-		for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
-			log.Debug("%v", line)
-			if ver, err := ParseVersion(line); err == nil {
-				log.Debug("ver: %v", ver)
-				if dep.VersionSpec.IsSatisfiedBy(ver) {
-					lib.Tag = line
-					lib.Version = ver
-					// move to this tag in the history
-					if err := cmd.Run("git", "checkout", lib.Tag); err != nil {
-						return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
-					}
-					break
-				}
-			} else {
-				log.Debug("Parse git tag err: %v", err)
+	picked, err := selectVersion(lib, dep, func() ([]string, error) {
+		if refs == nil {
+			var err error
+			if refs, err = self.refs(lib); err != nil {
+				return nil, err
 			}
 		}
+		return refs.tags(), nil
+	})
+	if err != nil {
+		return nil, err
+	} else if picked {
+		fetchRef = "refs/tags/" + lib.Tag
+		commit = refs[fetchRef]
 	}
 
-	// fail if the tag cannot be determined.
-	if lib.Version == nil {
-		return nil, fmt.Errorf("Cannot find a tag for dependency version specification: %v.", lib.VersionSpec)
+	// check out the source tree, unless it's already in the cache
+	repoUrl := lib.Url.String()
+	key := CacheKey("git", repoUrl, commit)
+	lib.SourceDir, err = self.Cache.SourceTree(key, func(dir string) error {
+		if self.Cache.Offline {
+			return &NotCachedError{Url: repoUrl}
+		}
+		return gitFetchCommit(lib.runContext(dir), repoUrl, commit, fetchRef)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	log.Info("Resolved: %s %v", lib.Import, lib.Version)
-	stripGitRepo(lib.TempDir)
+	if lib.Version.Major >= 0 {
+		log.Info("Resolved: %s %v", lib.Import, lib.Version)
+	}
 	return lib, nil
 }
 
+func (self *GitSCM) ListVersions(dep *Dependency) ([]*TaggedVersion, error) {
+	lib := NewLibrary(dep)
+
+	log.Info("Fetching Git tags: '%s'", lib.Import)
+
+	// the remote lists every tag, without fetching anything
+	refs, err := self.refs(lib)
+	if err != nil {
+		return nil, err
+	}
+	versions := dep.TaggedVersions(refs.tags())
+	for _, version := range versions {
+		version.Commit = refs["refs/tags/"+version.Tag]
+	}
+	return versions, nil
+}
+
+func (self *GitSCM) BranchHead(dep *Dependency) (string, error) {
+	branch := dep.Branch
+	if branch == "" {
+		branch = "master"
+	}
+	if dep.Url == nil {
+		return "", fmt.Errorf("No url for dependency: '%s'", dep.Import)
+	}
+	if self.Cache.Offline {
+		return "", &NotCachedError{Url: dep.Url.String()}
+	}
+
+	cmd := NewRunContext("")
+	if err := cmd.Run("git", "ls-remote", dep.Url.String(), "refs/heads/"+branch); err != nil {
+		return "", fmt.Errorf("Cannot list remote branch: '%s'", dep.Url.String())
+	}
+	fields := strings.Fields(cmd.CombinedOutput)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("No branch '%s' for dependency: '%s'", branch, dep.Import)
+	}
+	return fields[0], nil
+}
+
 func (self *GitSCM) ToDSD(*Library) string {
 	return ""
 }
@@ -25,7 +25,9 @@ THE SOFTWARE.
 import (
 	log "grapnel/log"
 	. "grapnel/testing"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -52,9 +54,66 @@ func TestGitSource(t *testing.T) {
 
 	log.Info("version: %v", dep.VersionSpec.String())
 
-	// test the resolver
-	libsrc := &GitSCM{}
-	if _, err = libsrc.Resolve(dep); err != nil {
+	// test the resolver against an empty cache
+	cacheRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(cacheRoot)
+	libsrc := &GitSCM{Cache: NewCache(cacheRoot)}
+	if lib, err := libsrc.Resolve(dep); err != nil {
 		t.Error("%v", err)
+	} else if !Exists(filepath.Join(lib.SourceDir, "README")) {
+		t.Errorf("Expected a source tree in %v", lib.SourceDir)
+	}
+
+	// the newest matching tag is picked
+	dep, err = NewDependency("foo/bar/baz", "git://localhost:9999/gitrepo", "1")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if lib.Tag != "v1.1" {
+		t.Errorf("Expected tag v1.1, got %v instead", lib.Tag)
+	}
+
+	// unversioned deps are pinned to the head of the branch
+	dep, err = NewDependency("foo/bar/baz", "git://localhost:9999/gitrepo", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	head, err := libsrc.Resolve(dep)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(head.Tag) != 40 {
+		t.Errorf("Expected a commit hash, got %v instead", head.Tag)
+	} else if !Exists(filepath.Join(head.SourceDir, "foo.txt")) {
+		t.Errorf("Expected the head source tree in %v", head.SourceDir)
+	}
+
+	// abbreviated hashes find the same commit
+	dep.Tag = head.Tag[:7]
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if lib.SourceDir != head.SourceDir {
+		t.Errorf("Expected source tree %v, got %v instead", head.SourceDir, lib.SourceDir)
+	}
+
+	// test the tag listing
+	if versions, err := libsrc.ListVersions(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if len(versions) != 2 {
+		t.Errorf("Expected 2 tagged versions, got %v instead", len(versions))
+	} else if versions[0].Tag != "v1.1" || versions[0].Commit != head.Tag {
+		t.Errorf("Expected v1.1 on commit %v, got %v on %v instead",
+			head.Tag, versions[0].Tag, versions[0].Commit)
+	}
+
+	// the newest tag is on the head of the branch
+	if commit, err := libsrc.BranchHead(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if commit != head.Tag {
+		t.Errorf("Expected branch head %v, got %v instead", head.Tag, commit)
 	}
 }
@@ -0,0 +1,214 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Resolved dependency graph.  Roots are the dependencies from the package
+// file, and each library's Dependencies are satisfied by other libraries in
+// the graph.
+type Graph struct {
+	Roots     []*Dependency
+	Libraries []*Library
+}
+
+func NewGraph(roots []*Dependency, libs []*Library) *Graph {
+	return &Graph{
+		Roots:     roots,
+		Libraries: libs,
+	}
+}
+
+// Returns the library that satisfies a dependency, or nil if there is none.
+func (self *Graph) Provider(dep *Dependency) *Library {
+	return FindProvider(self.Libraries, dep.Import)
+}
+
+// Returns every chain of dependencies from a root to the library that
+// provides importPath.  Each chain starts with a root dependency.
+func (self *Graph) Paths(importPath string) [][]*Dependency {
+	target := FindProvider(self.Libraries, importPath)
+	if target == nil {
+		return nil
+	}
+	results := [][]*Dependency{}
+	onPath := map[*Library]bool{}
+	var walk func(deps []*Dependency, path []*Dependency)
+	walk = func(deps []*Dependency, path []*Dependency) {
+		for _, dep := range deps {
+			provider := self.Provider(dep)
+			if provider == nil || onPath[provider] {
+				continue
+			}
+			next := append(append([]*Dependency{}, path...), dep)
+			if provider == target {
+				results = append(results, next)
+				continue
+			}
+			onPath[provider] = true
+			walk(provider.Dependencies, next)
+			onPath[provider] = false
+		}
+	}
+	walk(self.Roots, []*Dependency{})
+	return results
+}
+
+// Describes a chain of dependencies, with what each step asked for and
+// what was picked for it.
+func (self *Graph) PathString(path []*Dependency) string {
+	steps := []string{"root"}
+	for _, dep := range path {
+		picked := "missing"
+		if provider := self.Provider(dep); provider != nil {
+			picked = provider.Selection()
+		}
+		steps = append(steps, fmt.Sprintf("%s (%s, picked %s)",
+			dep.Import, dep.Constraint(), picked))
+	}
+	return strings.Join(steps, " -> ")
+}
+
+// Writes the graph in Graphviz DOT format.
+func (self *Graph) ToDot(writer io.Writer) {
+	fmt.Fprintf(writer, "digraph dependencies {\n")
+	fmt.Fprintf(writer, "  \"root\" [shape=box];\n")
+	for _, lib := range self.Libraries {
+		fmt.Fprintf(writer, "  %q [label=%q];\n", lib.Import,
+			fmt.Sprintf("%s\n%s (%s)", lib.Import, lib.Selection(), lib.Type))
+	}
+	edges := func(from string, deps []*Dependency) {
+		for _, dep := range deps {
+			to := dep.Import
+			if provider := self.Provider(dep); provider != nil {
+				to = provider.Import
+			}
+			fmt.Fprintf(writer, "  %q -> %q [label=%q];\n", from, to, dep.Constraint())
+		}
+	}
+	edges("root", self.Roots)
+	for _, lib := range self.Libraries {
+		edges(lib.Import, lib.Dependencies)
+	}
+	fmt.Fprintf(writer, "}\n")
+}
+
+type jsonEdge struct {
+	Import     string `json:"import"`
+	Constraint string `json:"constraint"`
+	Provider   string `json:"provider,omitempty"`
+}
+
+type jsonNode struct {
+	Import       string     `json:"import"`
+	Version      string     `json:"version"`
+	Tag          string     `json:"tag,omitempty"`
+	Type         string     `json:"type"`
+	Url          string     `json:"url,omitempty"`
+	Dependencies []jsonEdge `json:"dependencies"`
+}
+
+type jsonGraph struct {
+	Roots     []jsonEdge `json:"roots"`
+	Libraries []jsonNode `json:"libraries"`
+}
+
+func (self *Graph) jsonEdges(deps []*Dependency) []jsonEdge {
+	results := []jsonEdge{}
+	for _, dep := range deps {
+		edge := jsonEdge{
+			Import:     dep.Import,
+			Constraint: dep.Constraint(),
+		}
+		if provider := self.Provider(dep); provider != nil {
+			edge.Provider = provider.Import
+		}
+		results = append(results, edge)
+	}
+	return results
+}
+
+// Writes the graph as JSON.
+func (self *Graph) ToJson(writer io.Writer) error {
+	result := jsonGraph{
+		Roots:     self.jsonEdges(self.Roots),
+		Libraries: []jsonNode{},
+	}
+	for _, lib := range self.Libraries {
+		node := jsonNode{
+			Import:       lib.Import,
+			Version:      lib.Selection(),
+			Tag:          lib.Tag,
+			Type:         lib.Type,
+			Dependencies: self.jsonEdges(lib.Dependencies),
+		}
+		if lib.Url != nil {
+			node.Url = lib.Url.String()
+		}
+		result.Libraries = append(result.Libraries, node)
+	}
+	data, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "%s\n", data)
+	return err
+}
+
+// Writes the graph as an indented tree, starting at the package file.  Each
+// library is expanded only once; later appearances are marked with '(*)'.
+func (self *Graph) ToTree(writer io.Writer) {
+	fmt.Fprintf(writer, "root\n")
+	self.writeTree(writer, self.Roots, "", map[*Library]bool{})
+}
+
+func (self *Graph) writeTree(writer io.Writer, deps []*Dependency, indent string, seen map[*Library]bool) {
+	for ii, dep := range deps {
+		branch, next := "+-- ", "|   "
+		if ii == len(deps)-1 {
+			branch, next = "\\-- ", "    "
+		}
+		provider := self.Provider(dep)
+		line := fmt.Sprintf("%s%s%s (%s)", indent, branch, dep.Import, dep.Constraint())
+		if provider == nil {
+			fmt.Fprintf(writer, "%s (missing)\n", line)
+			continue
+		}
+		if provider.Import != dep.Import {
+			line += " via " + provider.Import
+		}
+		line += fmt.Sprintf(" -> %s [%s]", provider.Selection(), provider.Type)
+		if seen[provider] {
+			fmt.Fprintf(writer, "%s (*)\n", line)
+			continue
+		}
+		fmt.Fprintf(writer, "%s\n", line)
+		seen[provider] = true
+		self.writeTree(writer, provider.Dependencies, indent+next, seen)
+	}
+}
@@ -0,0 +1,124 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	log "grapnel/log"
+	"strings"
+	"testing"
+)
+
+func testGraph(t *testing.T) *Graph {
+	resolver := newTestRepoResolver(map[string][]testRelease{
+		"liba":   {{"1.0", []string{"shared", "1"}}},
+		"libb":   {{"2.0", []string{"shared", "1"}}},
+		"shared": {{"1.2", nil}},
+	})
+	graph, err := resolver.ResolveGraph(testDeps(t, "liba", "1", "libb", "2"))
+	if err != nil {
+		t.Fatalf("Error resolving dependencies: %v", err)
+	}
+	return graph
+}
+
+func TestGraphTree(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	buf := &bytes.Buffer{}
+	testGraph(t).ToTree(buf)
+	expected := "root\n" +
+		"+-- liba (= 1.*.*) -> 1.0.* [test]\n" +
+		"|   \\-- shared (= 1.*.*) -> 1.2.* [test]\n" +
+		"\\-- libb (= 2.*.*) -> 2.0.* [test]\n" +
+		"    \\-- shared (= 1.*.*) -> 1.2.* [test] (*)\n"
+	if buf.String() != expected {
+		t.Errorf("Expected tree:\n%v\nGot:\n%v", expected, buf.String())
+	}
+}
+
+func TestGraphDot(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	buf := &bytes.Buffer{}
+	testGraph(t).ToDot(buf)
+	for _, line := range []string{
+		`"root" -> "liba" [label="= 1.*.*"];`,
+		`"libb" -> "shared" [label="= 1.*.*"];`,
+		`"shared" [label="shared\n1.2.* (test)"];`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected '%v' in DOT output:\n%v", line, buf.String())
+		}
+	}
+}
+
+func TestGraphJson(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	buf := &bytes.Buffer{}
+	if err := testGraph(t).ToJson(buf); err != nil {
+		t.Fatalf("Error writing JSON: %v", err)
+	}
+	result := jsonGraph{}
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("Error reading JSON back: %v", err)
+	}
+	if len(result.Roots) != 2 || len(result.Libraries) != 3 {
+		t.Fatalf("Unexpected graph shape: %v", buf.String())
+	}
+	lib := result.Libraries[0]
+	if lib.Import != "liba" || lib.Type != "test" || lib.Version != "1.0.*" {
+		t.Errorf("Unexpected node: %v", lib)
+	}
+	if len(lib.Dependencies) != 1 || lib.Dependencies[0].Provider != "shared" {
+		t.Errorf("Unexpected edges: %v", lib.Dependencies)
+	}
+}
+
+func TestGraphPaths(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	graph := testGraph(t)
+	paths := graph.Paths("shared")
+	if len(paths) != 2 {
+		t.Fatalf("Expected 2 paths, got %v instead", len(paths))
+	}
+	for ii, expected := range []string{
+		"root -> liba (= 1.*.*, picked 1.0.*) -> shared (= 1.*.*, picked 1.2.*)",
+		"root -> libb (= 2.*.*, picked 2.0.*) -> shared (= 1.*.*, picked 1.2.*)",
+	} {
+		if value := graph.PathString(paths[ii]); value != expected {
+			t.Errorf("Expected '%v', got '%v' instead", expected, value)
+		}
+	}
+
+	// packages within a library are found through the library
+	if paths := graph.Paths("shared/subpackage"); len(paths) != 2 {
+		t.Errorf("Expected 2 paths to a subpackage, got %v instead", len(paths))
+	}
+	if paths := graph.Paths("unknown"); len(paths) != 0 {
+		t.Errorf("Expected no paths to an unknown import, got %v", paths)
+	}
+}
@@ -0,0 +1,210 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	log "grapnel/log"
+	"strings"
+)
+
+var HgRewriteRules = RewriteRuleArray{
+	// rewrite rules for misc mercurial resolvers
+	TypeResolverRule("hg-path", "path", `.*\.hg$`, `hg`),
+
+	// 'hg+' schemes, like 'hg+https://example.com/repo'
+	BuildRewriteRule("hg-scheme", StringMap{
+		"type":   `^$`,
+		"scheme": `^hg\+`,
+	}, StringMap{
+		"scheme": `{{ replace .scheme "^hg\\+(.*)$" "$1" }}`,
+		"type":   `hg`,
+	}),
+}
+
+type HgSCM struct {
+	Cache *Cache
+}
+
+// Returns the path to a mirror of a mercurial repository.  The clone or pull
+// is killed if cancel is closed.
+func (self *HgSCM) hgMirror(repoUrl string, cancel <-chan struct{}) (string, error) {
+	return self.Cache.Mirror("hg", repoUrl, func(dir string) error {
+		return (&RunContext{WorkingDirectory: dir, Cancel: cancel}).Run(
+			"hg", "clone", "--noupdate", repoUrl, dir)
+	}, func(dir string) error {
+		return (&RunContext{WorkingDirectory: dir, Cancel: cancel}).Run("hg", "pull")
+	})
+}
+
+// mirrors the library repository, trying a url built from the import if need be
+func (self *HgSCM) mirror(lib *Library) (string, error) {
+	return mirrorLibrary(lib, []string{"https", "http", "ssh"},
+		func(repoUrl string) (string, error) {
+			return self.hgMirror(repoUrl, lib.cancel)
+		})
+}
+
+// finds the changeset id that a tag, branch or node refers to
+func hgNode(cmd *RunContext, rev string) (string, error) {
+	if err := cmd.Run("hg", "log", "-r", rev, "--template", "{node}"); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(cmd.CombinedOutput), nil
+}
+
+// lists the tags in a repository
+func hgTags(cmd *RunContext) ([]string, error) {
+	nodes, err := hgTagNodes(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return tagNames(nodes), nil
+}
+
+// maps the tags in a repository to the changeset id each one is on
+func hgTagNodes(cmd *RunContext) (map[string]string, error) {
+	if err := cmd.Run("hg", "tags", "--debug"); err != nil {
+		return nil, fmt.Errorf("Failed to acquire tag list for dependency")
+	}
+	results := map[string]string{}
+	for _, line := range strings.Split(cmd.CombinedOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] == "tip" {
+			continue
+		}
+		// each tag is followed by 'rev:node', and maybe 'local'
+		for _, field := range fields[1:] {
+			if ii := strings.Index(field, ":"); ii >= 0 {
+				results[fields[0]] = field[ii+1:]
+			}
+		}
+	}
+	return results, nil
+}
+
+func (self *HgSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+
+	// fix the tag, and default branch
+	if lib.Branch == "" {
+		lib.Branch = "default"
+	}
+	if lib.Tag == "" {
+		lib.Tag = "HEAD"
+	}
+
+	log.Info("Fetching Mercurial Dependency: '%s'", lib.Import)
+
+	// bring the mirror up to date, and work from there
+	mirror, err := self.mirror(lib)
+	if err != nil {
+		return nil, err
+	}
+	cmd := lib.runContext(mirror)
+
+	// find the specified changeset - may be a tag, node or HEAD of the branch
+	rev := lib.Tag
+	if rev == "HEAD" {
+		rev = lib.Branch
+	}
+	node, err := hgNode(cmd, rev)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
+	}
+
+	// Pin the Tag to a changeset id if we just have "HEAD" as the 'Tag'
+	if lib.Tag == "HEAD" {
+		lib.Tag = node
+	}
+
+	if picked, err := selectVersion(lib, dep, func() ([]string, error) {
+		return hgTags(cmd)
+	}); err != nil {
+		return nil, err
+	} else if picked {
+		if node, err = hgNode(cmd, lib.Tag); err != nil {
+			return nil, fmt.Errorf("Failed to checkout tag: '%s'", lib.Tag)
+		}
+	}
+
+	// check out the source tree, unless it's already in the cache
+	key := CacheKey("hg", lib.Url.String(), node)
+	lib.SourceDir, err = self.Cache.SourceTree(key, func(dir string) error {
+		return cmd.Run("hg", "archive", "--config", "ui.archivemeta=false",
+			"-r", node, "-t", "files", dir)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if lib.Version.Major >= 0 {
+		log.Info("Resolved: %s %v", lib.Import, lib.Version)
+	}
+	return lib, nil
+}
+
+func (self *HgSCM) ListVersions(dep *Dependency) ([]*TaggedVersion, error) {
+	lib := NewLibrary(dep)
+
+	log.Info("Fetching Mercurial tags: '%s'", lib.Import)
+
+	mirror, err := self.mirror(lib)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := hgTagNodes(lib.runContext(mirror))
+	if err != nil {
+		return nil, err
+	}
+	versions := dep.TaggedVersions(tagNames(nodes))
+	setTagCommits(versions, nodes)
+	return versions, nil
+}
+
+func (self *HgSCM) BranchHead(dep *Dependency) (string, error) {
+	branch := dep.Branch
+	if branch == "" {
+		branch = "default"
+	}
+	if dep.Url == nil {
+		return "", fmt.Errorf("No url for dependency: '%s'", dep.Import)
+	}
+	if self.Cache.Offline {
+		return "", &NotCachedError{Url: dep.Url.String()}
+	}
+
+	cmd := NewRunContext("")
+	if err := cmd.Run("hg", "identify", "--debug", "--id", "-r", branch, dep.Url.String()); err != nil {
+		return "", fmt.Errorf("Cannot identify remote branch: '%s'", dep.Url.String())
+	}
+	fields := strings.Fields(cmd.CombinedOutput)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("No branch '%s' for dependency: '%s'", branch, dep.Import)
+	}
+	return fields[0], nil
+}
+
+func (self *HgSCM) ToDSD(*Library) string {
+	return ""
+}
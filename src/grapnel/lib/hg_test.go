@@ -0,0 +1,122 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	. "grapnel/testing"
+	url "grapnel/url"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestHgRewrite(t *testing.T) {
+	rules := RewriteRuleArray{}
+	rules = append(rules, BasicRewriteRules...)
+	rules = append(rules, HgRewriteRules...)
+
+	for _, test := range []struct {
+		Src *Dependency
+		Dst *Dependency
+	}{
+		{
+			Src: &Dependency{
+				Import: "example.com/foo/bar",
+				Url:    url.MustParse("hg+https://example.com/foo/bar"),
+			},
+			Dst: &Dependency{
+				Import: "example.com/foo/bar",
+				Url:    url.MustParse("https://example.com/foo/bar"),
+				Type:   "hg",
+			},
+		}, {
+			Src: &Dependency{
+				Import: "example.com/foo/bar.hg",
+			},
+			Dst: &Dependency{
+				Import: "example.com/foo/bar.hg",
+				Url:    url.MustParse("http://example.com/foo/bar.hg"),
+				Type:   "hg",
+			},
+		},
+	} {
+		if err := rules.Apply(test.Src); err != nil {
+			t.Errorf("Error during replacement %v; Src: %v", err, test.Src.Flatten())
+		}
+		if !test.Src.Equal(test.Dst) {
+			t.Errorf("Error during replacement Src: %#v; Dst: %#v",
+				test.Src.Flatten(), test.Dst.Flatten())
+		}
+	}
+}
+
+func TestHgSource(t *testing.T) {
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg is not installed")
+	}
+	InitTestLogging()
+
+	basePath := BuildTestHgRepo("hgrepo")
+	defer os.RemoveAll(basePath)
+	repoUrl := "file://" + filepath.Join(basePath, "hgrepo")
+
+	cacheRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(cacheRoot)
+	libsrc := &HgSCM{Cache: NewCache(cacheRoot)}
+
+	// versioned
+	dep, err := NewDependency("foo/bar/baz", repoUrl, "1.0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if lib.Tag != "v1.0" {
+		t.Errorf("Expected tag v1.0, got %v instead", lib.Tag)
+	} else if !Exists(filepath.Join(lib.SourceDir, "README")) {
+		t.Errorf("Expected a source tree in %v", lib.SourceDir)
+	}
+
+	// unversioned deps are pinned to a changeset id
+	dep, err = NewDependency("foo/bar/baz", repoUrl, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if len(lib.Tag) != 40 {
+		t.Errorf("Expected a changeset id, got %v instead", lib.Tag)
+	}
+
+	// test the tag listing
+	if versions, err := libsrc.ListVersions(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if len(versions) != 2 {
+		t.Errorf("Expected 2 tagged versions, got %v instead", len(versions))
+	}
+}
@@ -27,16 +27,18 @@ import (
 	"go/build"
 	log "grapnel/log"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 )
 
 // contains resolved factors from the parent depdendency specification
 type Library struct {
 	Dependency
 	Version      *Version
-	TempDir      string
+	SourceDir    string   // source tree to install from, which may be shared
 	Provides     []string // imports provided by this library
 	Dependencies []*Dependency
 }
@@ -50,6 +52,18 @@ func NewLibrary(dep *Dependency) *Library {
 	return result
 }
 
+// Describes the version or commit that was picked for this library.
+func (self *Library) Selection() string {
+	if self.Version != nil && self.Version.Major >= 0 {
+		return self.Version.String()
+	} else if self.IsLocal() {
+		return "local"
+	} else if self.Tag != "" {
+		return self.Tag
+	}
+	return "unversioned"
+}
+
 func (self *Library) Install(installRoot string) error {
 	// set up root target dir
 	importPath := filepath.Join(installRoot, self.Import)
@@ -60,26 +74,118 @@ func (self *Library) Install(installRoot string) error {
 	}
 
 	// move everything over
-	if err := CopyFileTree(importPath, self.TempDir); err != nil {
+	copyTree := CopyFileTree
+	if self.IsLocal() {
+		copyTree = copyLocalTree
+	}
+	if err := copyTree(importPath, self.SourceDir); err != nil {
 		log.Info("%s", err.Error())
 		return fmt.Errorf("Error while walking dependency file tree")
 	}
 	return nil
 }
 
-func (self *Library) Destroy() error {
-	return os.Remove(self.TempDir)
+// Removes the installed copy of this library from installRoot, along with
+// any parent directories that are left empty.  Libraries installed under it,
+// whose imports are listed in keep, are left in place.
+func (self *Library) Uninstall(installRoot string, keep []string) error {
+	importPath := filepath.Join(installRoot, self.Import)
+	log.Debug("uninstalling from: %s", importPath)
+	kept := map[string]bool{}
+	for _, keepImport := range keep {
+		if strings.HasPrefix(keepImport, self.Import+"/") {
+			kept[filepath.Join(installRoot, keepImport)] = true
+		}
+	}
+	if err := removeTreeExcept(importPath, kept); err != nil {
+		return fmt.Errorf("Could not remove directory: '%s'", importPath)
+	}
+	root := filepath.Clean(installRoot)
+	for dir := filepath.Dir(importPath); dir != root && dir != "."; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break // not empty
+		}
+	}
+	return nil
+}
+
+// Removes dir and everything under it, apart from the paths in keep and the
+// directories that lead to them.
+func removeTreeExcept(dir string, keep map[string]bool) error {
+	if len(keep) == 0 {
+		return os.RemoveAll(dir)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(dir, entry.Name())
+		if keep[entryPath] {
+			continue
+		}
+		leadsToKept := false
+		for keepPath := range keep {
+			leadsToKept = leadsToKept || strings.HasPrefix(keepPath, entryPath+string(filepath.Separator))
+		}
+		if leadsToKept && entry.IsDir() {
+			err = removeTreeExcept(entryPath, keep)
+		} else {
+			err = os.RemoveAll(entryPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	os.Remove(dir) // only if nothing was kept
+	return nil
+}
+
+// Builds a library out of a locked dependency, taking the version as-is.
+func NewLockedLibrary(dep *Dependency) *Library {
+	lib := NewLibrary(dep)
+	if dep.VersionSpec.IsUnversioned() {
+		lib.Version = NewVersion(-1, -1, -1)
+	} else {
+		lib.Version = NewVersion(dep.VersionSpec.Major, dep.VersionSpec.Minor,
+			dep.VersionSpec.Subminor)
+		lib.Version.Prerelease = dep.VersionSpec.Prerelease
+	}
+	return lib
+}
+
+// Builds a library out of a locked dependency that is already installed
+// under installRoot.  Dependencies are scanned from the installed copy.
+func NewInstalledLibrary(dep *Dependency, installRoot string) (*Library, error) {
+	lib := NewLockedLibrary(dep)
+
+	libPath := filepath.Join(installRoot, lib.Import)
+	if !Exists(libPath) {
+		return lib, nil // nothing to scan
+	}
+	if err := lib.addDependenciesFrom(libPath); err != nil {
+		return nil, err
+	}
+	for _, libDep := range lib.Dependencies {
+		libDep.Parent = lib
+	}
+	return lib, nil
 }
 
 func (self *Library) AddDependencies() error {
-	if self.TempDir == "" {
+	if self.SourceDir == "" {
 		return nil // do nothing if there's nothing to search
 	}
+	return self.addDependenciesFrom(self.SourceDir)
+}
 
+func (self *Library) addDependenciesFrom(libPath string) error {
 	// get dependencies via lockfile or grapnelfile
 	if deplist, err := LoadGrapnelDepsfile(
-		path.Join(self.TempDir, "grapnel-lock.toml"),
-		path.Join(self.TempDir, "grapnel.toml")); err != nil {
+		path.Join(libPath, "grapnel-lock.toml"),
+		path.Join(libPath, "grapnel.toml")); err != nil {
 		return err
 	} else if deplist != nil {
 		self.Dependencies = append(self.Dependencies, deplist...)
@@ -87,7 +193,7 @@ func (self *Library) AddDependencies() error {
 	}
 
 	// figure out the provided modules in this library
-	if importPaths, err := GetDirectories(self.TempDir); err != nil {
+	if importPaths, err := GetDirectories(libPath); err != nil {
 		return err
 	} else {
 		// fully qualify the set of paths
@@ -97,7 +203,7 @@ func (self *Library) AddDependencies() error {
 	}
 
 	// attempt get dependencies via raw import statements instead
-	pkg, err := build.ImportDir(self.TempDir, 0)
+	pkg, err := build.ImportDir(libPath, 0)
 	if err != nil {
 		log.Debug("Failed to get go imports for %v", err)
 		log.Warn("No Go imports to process for %v", self.Import)
@@ -122,7 +228,9 @@ func (self *Library) AddDependencies() error {
 
 func (self *Library) ToToml(writer io.Writer) {
 	fmt.Fprintf(writer, "\n[[dependencies]]\n")
-	if self.Version.Major > 0 {
+	if self.IsLocal() {
+		fmt.Fprintf(writer, "# Local override; not reproducible on other machines\n")
+	} else if self.Version.Major >= 0 {
 		fmt.Fprintf(writer, "version = \"%v\"\n", self.Version)
 	} else {
 		fmt.Fprintf(writer, "# Unversioned\n")
@@ -130,10 +238,15 @@ func (self *Library) ToToml(writer io.Writer) {
 	if self.Type != "" {
 		fmt.Fprintf(writer, "type = \"%s\"\n", self.Type)
 	}
+	if self.VersionScheme != "" {
+		fmt.Fprintf(writer, "scheme = \"%s\"\n", self.VersionScheme)
+	}
 	if self.Import != "" {
 		fmt.Fprintf(writer, "import = \"%s\"\n", self.Import)
 	}
-	if self.Url != nil {
+	if self.Path != "" {
+		fmt.Fprintf(writer, "path = \"%s\"\n", self.Path)
+	} else if self.Url != nil {
 		fmt.Fprintf(writer, "url = \"%s\"\n", self.Url.String())
 	}
 	if self.Branch != "" {
@@ -146,6 +259,41 @@ func (self *Library) ToToml(writer io.Writer) {
 		//}
 		fmt.Fprintf(writer, "tag = \"%s\"\n", self.Tag)
 	}
+	if self.Sha256 != "" {
+		fmt.Fprintf(writer, "sha256 = \"%s\"\n", self.Sha256)
+	}
+	if self.Sum != "" {
+		fmt.Fprintf(writer, "sum = \"%s\"\n", self.Sum)
+	}
+	if len(self.Rewrites) > 0 {
+		fmt.Fprintf(writer, "rewrites = [\"%s\"]\n", strings.Join(self.Rewrites, "\", \""))
+		if self.Original != nil {
+			requestedToToml(writer, self.Original)
+		}
+	}
+}
+
+// Writes what was asked for, before the rewrite rules got to it.
+func requestedToToml(writer io.Writer, dep *Dependency) {
+	fmt.Fprintf(writer, "[dependencies.requested]\n")
+	if dep.Import != "" {
+		fmt.Fprintf(writer, "import = \"%s\"\n", dep.Import)
+	}
+	if dep.Url != nil {
+		fmt.Fprintf(writer, "url = \"%s\"\n", dep.Url.String())
+	}
+	if dep.VersionSpec != nil && !dep.VersionSpec.IsUnversioned() {
+		fmt.Fprintf(writer, "version = \"%v\"\n", dep.VersionSpec)
+	}
+	if dep.Type != "" {
+		fmt.Fprintf(writer, "type = \"%s\"\n", dep.Type)
+	}
+	if dep.Branch != "" {
+		fmt.Fprintf(writer, "branch = \"%s\"\n", dep.Branch)
+	}
+	if dep.Tag != "" {
+		fmt.Fprintf(writer, "tag = \"%s\"\n", dep.Tag)
+	}
 }
 
 func (self *Library) ToDsd(writer io.Writer) {
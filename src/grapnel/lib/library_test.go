@@ -23,6 +23,9 @@ THE SOFTWARE.
 */
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -45,3 +48,49 @@ func TestNewLibrary(t *testing.T) {
 			lib.Url.String(), "http://github.com/foo/bar")
 	}
 }
+
+func TestLibraryUninstall(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(root)
+	for _, file := range []string{
+		"github.com/foo/foo.go",
+		"github.com/foo/sub/sub.go",
+		"github.com/foo/bar/bar.go",
+		"github.com/foo/bar/deep/deep.go",
+	} {
+		filename := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := ioutil.WriteFile(filename, []byte("package x"), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	// a kept library nested under the removed one stays, whole
+	lib := &Library{Dependency: Dependency{Import: "github.com/foo"}}
+	if err := lib.Uninstall(root, []string{"github.com/foo/bar", "github.com/other"}); err != nil {
+		t.Fatalf("Error uninstalling: %v", err)
+	}
+	for file, expected := range map[string]bool{
+		"github.com/foo/foo.go":           false,
+		"github.com/foo/sub":              false,
+		"github.com/foo/bar/bar.go":       true,
+		"github.com/foo/bar/deep/deep.go": true,
+	} {
+		if Exists(filepath.Join(root, file)) != expected {
+			t.Errorf("Expected %v to exist: %v", file, expected)
+		}
+	}
+
+	// with nothing kept, the empty parents go too
+	if err := lib.Uninstall(root, nil); err != nil {
+		t.Fatalf("Error uninstalling: %v", err)
+	}
+	if Exists(filepath.Join(root, "github.com")) {
+		t.Errorf("Expected the empty parent directories to be removed")
+	}
+}
@@ -0,0 +1,219 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	toml "github.com/pelletier/go-toml"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The comment at the top of every lock file.
+const lockFileHeader = "# Generated by grapnel; edit grapnel.toml and run 'grapnel update' instead.\n"
+
+// The version of the lock file format.  Bump it whenever the format changes,
+// and have LoadLockMetadata say how to read the older versions.
+const LockSchemaVersion = 1
+
+// The [metadata] table at the top of a lock file.
+type LockMetadata struct {
+	SchemaVersion  int
+	GrapnelVersion string // the version of grapnel that wrote the lock file
+	InputDigest    string // digest of the package file and rewrite rules it came from
+}
+
+// Digests what a lock file is resolved from: the dependencies in a package
+// file, in order, and the project's own rewrite rules.  Comments, formatting
+// and key order in the package file make no difference, and neither does
+// where the project is; local paths are taken relative to packageDir, where
+// the package file is kept.
+func InputDigest(packageDir string, deps []*Dependency, rules RewriteRuleArray) string {
+	hash := sha256.New()
+	for _, dep := range deps {
+		depPath := dep.Path
+		if depPath != "" {
+			if relative, err := relativePath(packageDir, depPath); err == nil {
+				depPath = filepath.ToSlash(relative)
+			}
+		}
+		version := ""
+		if dep.VersionSpec != nil && !dep.VersionSpec.IsUnversioned() {
+			version = dep.VersionSpec.String()
+		}
+		depUrl := ""
+		if dep.Url != nil {
+			depUrl = dep.Url.String()
+		}
+		fmt.Fprintf(hash, "dependency %q %q %q %q %q %q %q %q %q\n", dep.Import, depUrl,
+			dep.Type, dep.Branch, dep.Tag, version, depPath, dep.Sha256, dep.VersionScheme)
+	}
+	for _, rule := range rules {
+		fmt.Fprintf(hash, "rule %s\n", rule)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+func (self *LockMetadata) ToToml(writer io.Writer) {
+	fmt.Fprintf(writer, "\n[metadata]\n")
+	fmt.Fprintf(writer, "schema_version = %d\n", self.SchemaVersion)
+	fmt.Fprintf(writer, "grapnel_version = \"%s\"\n", self.GrapnelVersion)
+	fmt.Fprintf(writer, "input_digest = \"%s\"\n", self.InputDigest)
+}
+
+// Reads the [metadata] table of a lock file.  Lock files written before
+// there was one come back as schema version 0, which is otherwise the same
+// as version 1.
+func LoadLockMetadata(filename string) (*LockMetadata, error) {
+	tree, err := toml.LoadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s", filename, err)
+	}
+	result := &LockMetadata{}
+	if table, ok := tree.Get("metadata").(*toml.TomlTree); ok {
+		schemaVersion, _ := table.GetDefault("schema_version", int64(0)).(int64)
+		result.SchemaVersion = int(schemaVersion)
+		result.GrapnelVersion, _ = table.GetDefault("grapnel_version", "").(string)
+		result.InputDigest, _ = table.GetDefault("input_digest", "").(string)
+	}
+	if result.SchemaVersion > LockSchemaVersion {
+		return nil, fmt.Errorf("Lock file '%s' is schema version %d, but this grapnel only reads up to version %d",
+			filename, result.SchemaVersion, LockSchemaVersion)
+	}
+	return result, nil
+}
+
+// Writes a lock file for libs in canonical form: a fixed header, the
+// metadata, then one entry per library, sorted by import.  The same libraries
+// always produce the same text, whatever order they were resolved in.  Local
+// paths are written relative to lockDir, where the lock file is kept.
+func WriteLockFile(writer io.Writer, lockDir string, metadata *LockMetadata, libs []*Library) error {
+	sorted := append([]*Library{}, libs...)
+	sort.SliceStable(sorted, func(ii, jj int) bool {
+		return sorted[ii].Import < sorted[jj].Import
+	})
+	if _, err := io.WriteString(writer, lockFileHeader); err != nil {
+		return err
+	}
+	if metadata != nil {
+		metadata.ToToml(writer)
+	}
+	for _, lib := range sorted {
+		if lib.Path != "" {
+			path, err := relativePath(lockDir, lib.Path)
+			if err != nil {
+				return err
+			}
+			relocated := *lib
+			relocated.Path = filepath.ToSlash(path)
+			lib = &relocated
+		}
+		lib.ToToml(writer)
+	}
+	return nil
+}
+
+// returns target relative to dir, when both are resolved from the working directory
+func relativePath(dir string, target string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absDir, absTarget)
+}
+
+// Loads the libraries cited by a lock file, and scans their installed copies
+// under installRoot for dependencies.  Nothing is fetched.
+func LoadLockedLibraries(lockFileName string, installRoot string) ([]*Library, error) {
+	deplist, err := LoadGrapnelDepsfile(lockFileName)
+	if err != nil {
+		return nil, err
+	} else if deplist == nil {
+		return nil, fmt.Errorf("Cannot open lock file: '%s'", lockFileName)
+	}
+
+	libs := []*Library{}
+	for _, dep := range deplist {
+		if lib, err := NewInstalledLibrary(dep, installRoot); err != nil {
+			return nil, fmt.Errorf("While scanning %v: %v", dep.Import, err)
+		} else {
+			libs = append(libs, lib)
+		}
+	}
+	return libs, nil
+}
+
+// Finds the library that supplies an import, either directly or as a
+// package within the library.
+func FindProvider(libs []*Library, importPath string) *Library {
+	var result *Library
+	for _, lib := range libs {
+		if lib.Import == importPath {
+			return lib
+		}
+		for _, provided := range lib.Provides {
+			if provided == importPath {
+				return lib
+			}
+		}
+		// settle for the longest library import that contains this one
+		if strings.HasPrefix(importPath, lib.Import+"/") &&
+			(result == nil || len(lib.Import) > len(result.Import)) {
+			result = lib
+		}
+	}
+	return result
+}
+
+// Returns the libraries that are needed to satisfy deps, either directly or
+// through the dependencies of other needed libraries.  Library order is kept.
+func ReachableLibraries(deps []*Dependency, libs []*Library) []*Library {
+	reached := map[*Library]bool{}
+	queue := deps
+	for len(queue) > 0 {
+		next := []*Dependency{}
+		for _, dep := range queue {
+			if lib := FindProvider(libs, dep.Import); lib != nil && !reached[lib] {
+				reached[lib] = true
+				next = append(next, lib.Dependencies...)
+			}
+		}
+		queue = next
+	}
+
+	results := []*Library{}
+	for _, lib := range libs {
+		if reached[lib] {
+			results = append(results, lib)
+		}
+	}
+	return results
+}
@@ -0,0 +1,244 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"bytes"
+	url "grapnel/url"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteLockFile(t *testing.T) {
+	newLib := func(importPath string, tag string) *Library {
+		lib := NewLibrary(&Dependency{
+			Import: importPath,
+			Type:   "git",
+			Url:    url.MustParse("http://example.com/" + importPath),
+			Branch: "master",
+			Tag:    tag,
+		})
+		lib.Version = NewVersion(1, 0, -1)
+		return lib
+	}
+	libs := []*Library{newLib("libc", "v1.0"), newLib("liba", "v1.0"), newLib("libb", "v1.0")}
+
+	// the order of resolution makes no difference
+	first := &bytes.Buffer{}
+	if err := WriteLockFile(first, "", nil, libs); err != nil {
+		t.Fatalf("Error writing lock file: %v", err)
+	}
+	second := &bytes.Buffer{}
+	if err := WriteLockFile(second, "", nil, []*Library{libs[1], libs[2], libs[0]}); err != nil {
+		t.Fatalf("Error writing lock file: %v", err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("Expected the same lock file both times:\n%v\n--\n%v", first, second)
+	}
+	if !strings.HasPrefix(first.String(), lockFileHeader) {
+		t.Errorf("Expected the lock file header first:\n%v", first)
+	}
+	order := []string{}
+	for _, line := range strings.Split(first.String(), "\n") {
+		if strings.HasPrefix(line, "import = ") {
+			order = append(order, line)
+		}
+	}
+	if strings.Join(order, ",") != `import = "liba",import = "libb",import = "libc"` {
+		t.Errorf("Expected entries sorted by import, got %v instead", order)
+	}
+	if libs[0].Import != "libc" {
+		t.Errorf("Expected the libraries passed in to keep their order")
+	}
+
+	// and the result reads back in
+	lockFile, err := ioutil.TempFile("", "grapnel-lock")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.Remove(lockFile.Name())
+	lockFile.Write(first.Bytes())
+	lockFile.Close()
+	deps, err := LoadGrapnelDepsfile(lockFile.Name())
+	if err != nil {
+		t.Fatalf("Error reading lock file: %v", err)
+	} else if len(deps) != 3 {
+		t.Errorf("Expected 3 dependencies, got %v instead", len(deps))
+	}
+}
+
+func TestLockFileRoundTrip(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	lockFileName := tempDir + "/grapnel-lock.toml"
+
+	// one library as requested, and one that a rewrite rule moved
+	plain := NewLibrary(&Dependency{
+		Import: "liba",
+		Type:   "git",
+		Url:    url.MustParse("http://example.com/liba"),
+		Branch: "master",
+		Tag:    "v1.0",
+		Sum:    "sha256:0123",
+	})
+	plain.Version = NewVersion(1, 0, -1)
+	requested, err := NewDependency("libb", "http://example.com/libb", "^2.1")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	moved := NewLibrary(&Dependency{
+		Import:   "libb",
+		Type:     "git",
+		Url:      url.MustParse("http://mirror.example.com/libb"),
+		Branch:   "master",
+		Tag:      "v2.1.3",
+		Original: requested,
+		Rewrites: []string{"mirror", "git-type"},
+	})
+	moved.Version = NewVersion(2, 1, 3)
+	metadata := &LockMetadata{
+		SchemaVersion:  LockSchemaVersion,
+		GrapnelVersion: "0.4",
+		InputDigest:    "sha256:4567",
+	}
+	first := &bytes.Buffer{}
+	if err := WriteLockFile(first, tempDir, metadata, []*Library{plain, moved}); err != nil {
+		t.Fatalf("Error writing lock file: %v", err)
+	}
+	for _, expected := range []string{`rewrites = ["mirror", "git-type"]`, "[dependencies.requested]"} {
+		if !strings.Contains(first.String(), expected) {
+			t.Errorf("Expected '%v' in the lock file:\n%v", expected, first)
+		}
+	}
+
+	// loading the lock file and writing it back changes nothing
+	ioutil.WriteFile(lockFileName, first.Bytes(), 0644)
+	loadedMetadata, err := LoadLockMetadata(lockFileName)
+	if err != nil {
+		t.Fatalf("Error loading metadata: %v", err)
+	}
+	deps, err := LoadGrapnelDepsfile(lockFileName)
+	if err != nil {
+		t.Fatalf("Error reading lock file: %v", err)
+	}
+	libs := []*Library{}
+	for _, dep := range deps {
+		libs = append(libs, NewLockedLibrary(dep))
+	}
+	second := &bytes.Buffer{}
+	if err := WriteLockFile(second, tempDir, loadedMetadata, libs); err != nil {
+		t.Fatalf("Error writing lock file: %v", err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("Expected the same lock file after a round trip:\n%v\n--\n%v", first, second)
+	}
+}
+
+func TestLockMetadata(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	lockFileName := tempDir + "/grapnel-lock.toml"
+
+	deps := testDeps(t, "liba", "1.0", "libb", "^2.1")
+	rules := RewriteRuleArray{TypeResolverRule("git-scheme", "scheme", `^git$`, `git`)}
+	metadata := &LockMetadata{
+		SchemaVersion:  LockSchemaVersion,
+		GrapnelVersion: "0.4",
+		InputDigest:    InputDigest(tempDir, deps, rules),
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteLockFile(buf, tempDir, metadata, nil); err != nil {
+		t.Fatalf("Error writing lock file: %v", err)
+	}
+	ioutil.WriteFile(lockFileName, buf.Bytes(), 0644)
+	if result, err := LoadLockMetadata(lockFileName); err != nil {
+		t.Errorf("Error loading metadata: %v", err)
+	} else if *result != *metadata {
+		t.Errorf("Expected %v, got %v instead", metadata, result)
+	}
+
+	// the digest follows the dependencies and rules, and nothing else
+	if InputDigest(tempDir, testDeps(t, "liba", "1.0", "libb", "^2.1"), rules) != metadata.InputDigest {
+		t.Errorf("Expected the same digest for the same dependencies")
+	}
+	if InputDigest(tempDir, testDeps(t, "liba", "1.1", "libb", "^2.1"), rules) == metadata.InputDigest {
+		t.Errorf("Expected a new digest for a new version")
+	}
+	if InputDigest(tempDir, deps, nil) == metadata.InputDigest {
+		t.Errorf("Expected a new digest without the rewrite rules")
+	}
+
+	// local paths count relative to the package file, wherever it is
+	local := func(packageDir string) string {
+		dep := &Dependency{Import: "libc", Type: "path", Path: filepath.Join(packageDir, "..", "libc")}
+		return InputDigest(packageDir, []*Dependency{dep}, nil)
+	}
+	if local(filepath.Join(tempDir, "one")) != local(filepath.Join(tempDir, "two", "three")) {
+		t.Errorf("Expected the same digest for the same relative path")
+	}
+
+	// older lock files have no metadata; newer ones can't be read
+	ioutil.WriteFile(lockFileName, []byte("[[dependencies]]\nimport = \"liba\"\n"), 0644)
+	if result, err := LoadLockMetadata(lockFileName); err != nil {
+		t.Errorf("Error loading metadata: %v", err)
+	} else if result.SchemaVersion != 0 || result.InputDigest != "" {
+		t.Errorf("Expected empty metadata, got %v instead", result)
+	}
+	ioutil.WriteFile(lockFileName, []byte("[metadata]\nschema_version = 99\n"), 0644)
+	if _, err := LoadLockMetadata(lockFileName); err == nil {
+		t.Errorf("Expected an error for a newer schema version")
+	}
+}
+
+func TestReachableLibraries(t *testing.T) {
+	newLib := func(importPath string, deps ...string) *Library {
+		lib := NewLibrary(&Dependency{Import: importPath})
+		for _, dep := range deps {
+			lib.Dependencies = append(lib.Dependencies, &Dependency{Import: dep})
+		}
+		return lib
+	}
+	libs := []*Library{
+		newLib("liba", "shared/sub"),
+		newLib("libb", "orphan"),
+		newLib("shared"),
+		newLib("orphan"),
+	}
+
+	results := ReachableLibraries([]*Dependency{&Dependency{Import: "liba"}}, libs)
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 libraries, got %v instead", len(results))
+	}
+	if results[0].Import != "liba" || results[1].Import != "shared" {
+		t.Errorf("Unexpected libraries: %v, %v", results[0].Import, results[1].Import)
+	}
+}
@@ -0,0 +1,128 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	log "grapnel/log"
+	"os"
+	"path/filepath"
+)
+
+// version control metadata that is left out of an installed local tree
+var localSkipDirs = map[string]bool{
+	".git": true,
+	".hg":  true,
+	".svn": true,
+	".bzr": true,
+}
+
+// Libraries taken from a directory on the local filesystem, as given by the
+// dependency's 'path', or a 'file' url.  These are for working on several
+// libraries at once, and are never reproducible on another machine.
+type PathSCM struct{}
+
+// returns the local directory for a dependency
+func localPath(dep *Dependency) (string, error) {
+	if dep.Path != "" {
+		return dep.Path, nil
+	} else if dep.Url != nil && dep.Url.Scheme == "file" {
+		return dep.Url.Path, nil
+	}
+	return "", fmt.Errorf("No local path for dependency: '%s'", dep.Import)
+}
+
+// copies a local tree to dest, without any version control metadata
+func copyLocalTree(dest string, src string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Info("%s", err.Error())
+			return fmt.Errorf("Error while walking file tree")
+		}
+		relativePath, _ := filepath.Rel(src, path)
+		destPath := filepath.Join(dest, relativePath)
+		if info.IsDir() {
+			if localSkipDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(destPath, 0755)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			log.Debug("Linking: %s", destPath)
+			if err := CopySymlink(path, destPath); err != nil {
+				return fmt.Errorf("Could not copy link '%s' to '%s'", path, destPath)
+			}
+			return nil
+		}
+		log.Debug("Copying: %s", destPath)
+		if err := CopyFileContents(path, destPath); err != nil {
+			return fmt.Errorf("Could not copy file '%s' to '%s'", path, destPath)
+		}
+		return nil
+	})
+}
+
+func (self *PathSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+	if !lib.VersionSpec.IsUnversioned() {
+		return nil, fmt.Errorf("Local path dependencies cannot be versioned: '%s'", lib.Import)
+	}
+
+	dir, err := localPath(dep)
+	if err != nil {
+		return nil, err
+	}
+	if lib.SourceDir, err = filepath.Abs(dir); err != nil {
+		return nil, err
+	}
+	if !Exists(lib.SourceDir) {
+		return nil, fmt.Errorf("Local path for '%s' does not exist: '%s'", lib.Import, dir)
+	}
+
+	lib.Version = NewVersion(-1, -1, -1)
+	log.Warn("Resolved: %v (local path %s; not reproducible)", lib.Import, dir)
+	return lib, nil
+}
+
+func (self *PathSCM) ListVersions(dep *Dependency) ([]*TaggedVersion, error) {
+	return []*TaggedVersion{}, nil // no versions to speak of
+}
+
+func (self *PathSCM) Available(dep *Dependency) error {
+	dir, err := localPath(dep)
+	if err != nil {
+		return err
+	}
+	if !Exists(dir) {
+		return fmt.Errorf("Local path for '%s' does not exist: '%s'", dep.Import, dir)
+	}
+	return nil
+}
+
+func (self *PathSCM) BranchHead(dep *Dependency) (string, error) {
+	return "", nil // never pinned to anything
+}
+
+func (self *PathSCM) ToDSD(*Library) string {
+	return ""
+}
@@ -0,0 +1,161 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"bytes"
+	. "grapnel/testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPathSource(t *testing.T) {
+	InitTestLogging()
+	basePath, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(basePath)
+
+	// a local library, along with its repository metadata
+	libPath := filepath.Join(basePath, "mylib")
+	for _, dir := range []string{"sub", ".git"} {
+		if err := os.MkdirAll(filepath.Join(libPath, dir), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	for _, file := range []string{"lib.go", "sub/sub.go", ".git/HEAD"} {
+		if err := ioutil.WriteFile(filepath.Join(libPath, file), []byte("package x"), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	// relative paths are relative to the file that cites them
+	projPath := filepath.Join(basePath, "proj")
+	if err := os.Mkdir(projPath, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	packageFile := filepath.Join(projPath, "grapnel.toml")
+	if err := ioutil.WriteFile(packageFile, []byte(
+		"[[dependencies]]\nimport = \"example.com/mylib\"\npath = \"../mylib\"\n"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	deps, err := LoadGrapnelDepsfile(packageFile)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if deps[0].Type != "path" || !deps[0].IsLocal() {
+		t.Errorf("Expected a local dependency, got type %v instead", deps[0].Type)
+	}
+	if deps[0].Path != libPath {
+		t.Errorf("Expected path %v, got %v instead", libPath, deps[0].Path)
+	}
+
+	resolver := NewResolver()
+	resolver.LibSources["path"] = &PathSCM{}
+	resolver.AddRewriteRules(BasicRewriteRules)
+	lib, err := resolver.Resolve(deps[0])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lib.Sum != "" {
+		t.Errorf("Expected no sum for a local library, got %v instead", lib.Sum)
+	}
+
+	// installed without the repository metadata
+	installRoot := filepath.Join(basePath, "src")
+	if err := lib.Install(installRoot); err != nil {
+		t.Fatalf("%v", err)
+	}
+	installPath := filepath.Join(installRoot, "example.com/mylib")
+	if !Exists(filepath.Join(installPath, "sub/sub.go")) {
+		t.Errorf("Expected an installed copy in %v", installPath)
+	}
+	if Exists(filepath.Join(installPath, ".git")) {
+		t.Errorf("Expected no .git directory in %v", installPath)
+	}
+
+	// the lock file keeps the path, and says it's local
+	buf := &bytes.Buffer{}
+	lib.ToToml(buf)
+	if !strings.Contains(buf.String(), "path = \""+libPath+"\"") ||
+		!strings.Contains(buf.String(), "# Local override") ||
+		strings.Contains(buf.String(), "url =") {
+		t.Errorf("Unexpected lock entry: %v", buf.String())
+	}
+
+	// local libraries can't be versioned, and must exist
+	dep, _ := NewDependency("example.com/mylib", "", "1.0")
+	dep.Path = libPath
+	if _, err := (&PathSCM{}).Resolve(dep); err == nil {
+		t.Errorf("Expected an error for a versioned local dependency")
+	}
+	dep, _ = NewDependency("example.com/mylib", "file://"+filepath.Join(basePath, "missing"), "")
+	if _, err := (&PathSCM{}).Resolve(dep); err == nil {
+		t.Errorf("Expected an error for a missing local path")
+	}
+}
+
+func TestPathLockRoundTrip(t *testing.T) {
+	InitTestLogging()
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(root)
+
+	// work from the project root, with the package file in a subdirectory
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(root)
+	os.MkdirAll("mylib", 0755)
+	os.MkdirAll("sub", 0755)
+	ioutil.WriteFile("sub/grapnel.toml", []byte("[[dependencies]]\nimport = \"example.com/mylib\"\npath = \"../mylib\"\n"), 0644)
+
+	deps, err := LoadGrapnelDepsfile("sub/grapnel.toml")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	lib := NewLibrary(deps[0])
+	lib.Version = NewVersion(-1, -1, -1)
+
+	// the lock file sits beside the package file, and says the same thing
+	buf := &bytes.Buffer{}
+	if err := WriteLockFile(buf, "sub", nil, []*Library{lib}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.Contains(buf.String(), "path = \"../mylib\"\n") {
+		t.Errorf("Expected the path relative to the lock file:\n%v", buf)
+	}
+	ioutil.WriteFile("sub/grapnel-lock.toml", buf.Bytes(), 0644)
+	locked, err := LoadGrapnelDepsfile("sub/grapnel-lock.toml")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if filepath.Clean(locked[0].Path) != "mylib" || !Exists(locked[0].Path) {
+		t.Errorf("Expected the locked path to lead back to mylib, got '%s'", locked[0].Path)
+	}
+}
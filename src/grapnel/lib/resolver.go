@@ -25,60 +25,227 @@ THE SOFTWARE.
 import (
 	"fmt"
 	log "grapnel/log"
+	"sort"
+	"strings"
+	"sync"
 )
 
 type LibSource interface {
 	Resolve(*Dependency) (*Library, error)
+	ListVersions(*Dependency) ([]*TaggedVersion, error)
+	BranchHead(*Dependency) (string, error)
 	ToDSD(*Library) string
 }
 
+// Implemented by a LibSource when listing versions says nothing about whether
+// a dependency can be fetched at all.
+type availabilityChecker interface {
+	Available(*Dependency) error
+}
+
+// a version of a dependency as advertised by a LibSource, and the tag that selects it
+type TaggedVersion struct {
+	Tag     string
+	Version *Version
+	Scheme  VersionScheme // orders the version; semantic precedence if nil
+	Commit  string        // commit the tag is on, as BranchHead names it; empty if unknown
+}
+
+// Returns true if self comes before other.
+func (self *TaggedVersion) Less(other *TaggedVersion) bool {
+	if self.Scheme != nil {
+		return self.Scheme.Compare(self, other) < 0
+	}
+	return self.Version.Less(other.Version)
+}
+
+// Parses tags into the versions they name, per scheme, newest first.  Tags
+// that don't name a version are left out.
+func TaggedVersions(scheme VersionScheme, tags []string) []*TaggedVersion {
+	results := []*TaggedVersion{}
+	for _, tag := range tags {
+		if ver, err := scheme.ParseVersion(tag); err == nil {
+			results = append(results, &TaggedVersion{Tag: tag, Version: ver, Scheme: scheme})
+		} else {
+			log.Debug("Parse tag err: %v", err)
+		}
+	}
+	SortVersions(results)
+	return results
+}
+
+// lists the tags in a map of tags to commits, in order
+func tagNames(commits map[string]string) []string {
+	results := []string{}
+	for tag := range commits {
+		results = append(results, tag)
+	}
+	sort.Strings(results)
+	return results
+}
+
+// Notes the commit that each tag is on, for the tags found in commits.
+func setTagCommits(versions []*TaggedVersion, commits map[string]string) {
+	for _, version := range versions {
+		version.Commit = commits[version.Tag]
+	}
+}
+
+// Sorts versions newest first, in the order of their scheme.  Tags for the
+// same version keep their order.
+func SortVersions(versions []*TaggedVersion) {
+	sort.SliceStable(versions, func(ii, jj int) bool {
+		return versions[jj].Less(versions[ii])
+	})
+}
+
+// Returns the newest version that satisfies spec, or nil if there is none.
+func NewestVersion(versions []*TaggedVersion, spec *VersionSpec) *TaggedVersion {
+	var result *TaggedVersion
+	for _, version := range versions {
+		if spec.IsSatisfiedBy(version.Version) &&
+			(result == nil || result.Less(version)) {
+			result = version
+		}
+	}
+	return result
+}
+
+// reported when a set of dependencies cannot be satisfied by any one library
+type ConflictError struct {
+	Import  string
+	Deps    []*Dependency
+	Library *Library // library already selected for the import, if any
+}
+
+// Lists every path from the root to the conflicting import, along with
+// the version specification on each side.
+func (self *ConflictError) Error() string {
+	lines := []string{fmt.Sprintf("Cannot reconcile dependencies for '%v':", self.Import)}
+	for _, dep := range self.Deps {
+		lines = append(lines, "  "+dep.PathString())
+	}
+	if self.Library != nil {
+		lines = append(lines, fmt.Sprintf("  already picked %s %s, via: %s",
+			self.Library.Import, self.Library.Selection(), self.Library.PathString()))
+	}
+	return strings.Join(lines, "\n")
+}
+
 type LibSourceMap map[string]LibSource
 
+// The number of calls to library sources that may run at once, by default.
+const DefaultJobs = 4
+
 type Resolver struct {
 	LibSources   LibSourceMap
 	RewriteRules RewriteRuleArray
+	Jobs         int // calls to library sources that may run at once
 }
 
 func NewResolver() *Resolver {
 	return &Resolver{
 		LibSources:   LibSourceMap{},
 		RewriteRules: RewriteRuleArray{},
+		Jobs:         DefaultJobs,
+	}
+}
+
+// the number of calls to library sources that may run at once
+func (self *Resolver) jobs() int {
+	if self.Jobs < 1 {
+		return DefaultJobs
 	}
+	return self.Jobs
 }
 
 func (self *Resolver) AddRewriteRules(rules RewriteRuleArray) {
 	self.RewriteRules = append(self.RewriteRules, rules...)
 }
 
-// resolve a single dependency
-func (self *Resolver) Resolve(dep *Dependency) (*Library, error) {
-	// apply rewrite rules
-	// TODO: consider preserving original dependency
+// find the LibSource for a dependency
+func (self *Resolver) libSource(dep *Dependency) (LibSource, error) {
+	// remember what was asked for, then apply rewrite rules
+	dep.keepOriginal()
 	if err := self.RewriteRules.Apply(dep); err != nil {
 		return nil, err
 	}
 
 	// match by registered type - rewrite rules should have set 'type' by now
 	if source, ok := self.LibSources[dep.Type]; ok {
-		var lib *Library
-		var err error
+		return source, nil
+	}
+	return nil, fmt.Errorf("Cannot identify resolver for dependency: '%v'", dep.Import)
+}
 
-		// resolve through the LibSource
-		lib, err = source.Resolve(dep)
-		if err != nil {
-			return nil, err
-		}
+// resolve a single dependency
+func (self *Resolver) Resolve(dep *Dependency) (*Library, error) {
+	source, err := self.libSource(dep)
+	if err != nil {
+		return nil, err
+	}
 
-		// follow up with lib specific touches
-		err = lib.AddDependencies()
+	// resolve through the LibSource
+	lib, err := source.Resolve(dep)
+	if err != nil {
+		return nil, err
+	}
+
+	// make sure we got what the lock file says we should have; local trees
+	// are expected to change, so they get no sum
+	if lib.SourceDir != "" && !lib.IsLocal() {
+		sum, err := TreeHash(lib.SourceDir)
 		if err != nil {
 			return nil, err
 		}
+		if dep.Sum != "" && dep.Sum != sum {
+			return nil, fmt.Errorf("Checksum mismatch for '%s': expected %s, fetched %s",
+				lib.Import, dep.Sum, sum)
+		}
+		lib.Sum = sum
+	}
+
+	// follow up with lib specific touches
+	if err := lib.AddDependencies(); err != nil {
+		return nil, err
+	}
 
-		return lib, nil
+	// remember where each dependency came from
+	for _, libDep := range lib.Dependencies {
+		libDep.Parent = lib
 	}
+	return lib, nil
+}
 
-	return nil, fmt.Errorf("Cannot identify resolver for dependency: '%v'", dep.Import)
+// list the available versions for a single dependency
+func (self *Resolver) ListVersions(dep *Dependency) ([]*TaggedVersion, error) {
+	source, err := self.libSource(dep)
+	if err != nil {
+		return nil, err
+	}
+	return source.ListVersions(dep)
+}
+
+// check that a dependency can be fetched, without resolving it
+func (self *Resolver) Available(dep *Dependency) error {
+	source, err := self.libSource(dep)
+	if err != nil {
+		return err
+	}
+	if checker, ok := source.(availabilityChecker); ok {
+		return checker.Available(dep)
+	}
+	_, err = source.ListVersions(dep)
+	return err
+}
+
+// find the commit at the head of a dependency's branch
+func (self *Resolver) BranchHead(dep *Dependency) (string, error) {
+	source, err := self.libSource(dep)
+	if err != nil {
+		return "", err
+	}
+	return source.BranchHead(dep)
 }
 
 // remove duplicates while preserving dependency order
@@ -112,7 +279,11 @@ func (self *Resolver) LibResolveDeps(libs map[string]*Library, deps []*Dependenc
 	for _, dep := range deps {
 		if lib, ok := libs[dep.Import]; ok {
 			if !dep.VersionSpec.IsSatisfiedBy(lib.Version) {
-				return nil, fmt.Errorf("Cannot reconcile '%v'", dep.Import)
+				return nil, &ConflictError{
+					Import:  dep.Import,
+					Deps:    []*Dependency{dep},
+					Library: lib,
+				}
 			}
 		} else {
 			tempQueue = append(tempQueue, dep)
@@ -121,64 +292,67 @@ func (self *Resolver) LibResolveDeps(libs map[string]*Library, deps []*Dependenc
 	return tempQueue, nil
 }
 
-// resolve all dependencies against configuration
-func (self *Resolver) ResolveDependencies(deps []*Dependency) ([]*Library, error) {
-	masterLibs := []*Library{}
-	resolved := map[string]*Library{}
-	results := make(chan *Library)
-	errors := make(chan error)
-	workQueue := deps
-
-	for len(workQueue) > 0 {
-		// de-duplicate the queue
-		var err error
-		if workQueue, err = self.DeduplicateDeps(workQueue); err != nil {
-			return nil, err
-		}
+// Reported when dependencies cannot be supplied at all.
+type MissingError struct {
+	Deps   []*Dependency
+	Errors []error
+}
 
-		// look for already resolved deps that may match
-		if workQueue, err = self.LibResolveDeps(resolved, workQueue); err != nil {
-			return nil, err
-		}
+func (self *MissingError) Error() string {
+	lines := []string{fmt.Sprintf("Cannot supply %d dependencies:", len(self.Deps))}
+	for ii, dep := range self.Deps {
+		lines = append(lines, fmt.Sprintf("  %s: %v", dep.Import, self.Errors[ii]))
+	}
+	return strings.Join(lines, "\n")
+}
 
-		// spawn goroutines for each dependency to be resolved
-		for _, dep := range workQueue {
-			go func(dep *Dependency) {
-				lib, err := self.Resolve(dep)
-				if err != nil {
-					errors <- err
-				} else {
-					results <- lib
-				}
-			}(dep)
-		}
+// Checks that each dependency can be fetched at all - its refs, mirror or
+// download - and reports every import that cannot.  Where
+// ResolveDependencies stops at the first failure, this finds them all.  The
+// checks share the solver's slots; dependencies of dependencies are left to
+// the solve.
+func (self *Resolver) FindMissing(deps []*Dependency) error {
+	solver := newSolver(self)
+	errs := make([]error, len(deps))
+	var wait sync.WaitGroup
+	for ii, dep := range deps {
+		wait.Add(1)
+		go func(ii int, dep *Dependency) {
+			defer wait.Done()
+			errs[ii] = solver.available(dep)
+		}(ii, dep)
+	}
+	wait.Wait()
 
-		// wait on all goroutines to finish or fail
-		tempQueue := make([]*Dependency, 0)
-		failed := false
-		for ii := 0; ii < len(workQueue); ii++ {
-			log.Debug("working on %s of  %s", ii, len(workQueue))
-			select {
-			case lib := <-results:
-				log.Debug("Reconciled library: %s", lib.Import)
-				resolved[lib.Import] = lib
-				masterLibs = append(masterLibs, lib)
-				for _, importPath := range lib.Provides {
-					log.Debug("Submodule:  %s", importPath)
-					resolved[importPath] = lib
-				}
-				tempQueue = append(tempQueue, lib.Dependencies...)
-			case err := <-errors:
-				log.Error(err)
-				failed = true
-			}
+	missing := &MissingError{}
+	seen := map[string]bool{}
+	for ii, dep := range deps {
+		if errs[ii] == nil || seen[dep.Import] {
+			continue
 		}
-		if failed {
-			return nil, fmt.Errorf("One or more errors while resolving dependencies.")
-		}
-		workQueue = tempQueue
+		seen[dep.Import] = true
+		missing.Deps = append(missing.Deps, dep)
+		missing.Errors = append(missing.Errors, errs[ii])
+	}
+	if len(missing.Deps) > 0 {
+		return missing
+	}
+	return nil
+}
+
+// resolve all dependencies against configuration, keeping the graph of
+// which library satisfies each dependency
+func (self *Resolver) ResolveGraph(deps []*Dependency) (*Graph, error) {
+	return newSolver(self).Solve(deps)
+}
+
+// resolve all dependencies against configuration
+func (self *Resolver) ResolveDependencies(deps []*Dependency) ([]*Library, error) {
+	graph, err := self.ResolveGraph(deps)
+	if err != nil {
+		return nil, err
 	}
-	return masterLibs, nil
+	return graph.Libraries, nil
 }
 
 func (self *Resolver) ToDsd(filename string, libs []*Library) error {
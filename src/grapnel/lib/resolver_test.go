@@ -23,8 +23,14 @@ THE SOFTWARE.
 */
 
 import (
+	"bytes"
 	log "grapnel/log"
 	url "grapnel/url"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -33,9 +39,20 @@ type testSCM struct{}
 func (self *testSCM) Resolve(dep *Dependency) (*Library, error) {
 	lib := &Library{}
 	lib.Dependency = *dep
+	lib.Version, _ = ParseVersion(dep.Tag)
 	return lib, nil
 }
 
+func (self *testSCM) ListVersions(dep *Dependency) ([]*TaggedVersion, error) {
+	return []*TaggedVersion{
+		&TaggedVersion{Tag: "1.0", Version: NewVersion(1, 0, -1)},
+	}, nil
+}
+
+func (self *testSCM) BranchHead(dep *Dependency) (string, error) {
+	return "", nil
+}
+
 func (self *testSCM) ToDSD(*Library) string {
 	return ""
 }
@@ -79,6 +96,72 @@ func TestResolver(t *testing.T) {
 	}
 }
 
+func TestResolverRewrites(t *testing.T) {
+	resolver := &Resolver{
+		LibSources: map[string]LibSource{
+			"test": &testSCM{},
+		},
+		RewriteRules: RewriteRuleArray{
+			BuildRewriteRule("moved", StringMap{"host": `^old\.com$`},
+				StringMap{"host": `new.com`, "type": `test`}),
+			BuildRewriteRule("unchanged", StringMap{"type": `test`},
+				StringMap{"type": `test`}),
+		},
+	}
+	dep := &Dependency{
+		Import:      "old.com/foo",
+		Url:         url.MustParse("http://old.com/foo"),
+		VersionSpec: NewVersionSpec(OpEq, 1, 0, -1),
+		Tag:         "1.0",
+	}
+	if _, err := resolver.ListVersions(dep); err != nil {
+		t.Fatalf("Error listing versions: %v", err)
+	}
+	lib, err := resolver.Resolve(dep)
+	if err != nil {
+		t.Fatalf("Error resolving dependency: %v", err)
+	}
+
+	// the rules only count once, and only if they changed something
+	if lib.Url.String() != "http://new.com/foo" {
+		t.Errorf("Expected a rewritten url, got %v instead", lib.Url)
+	}
+	if !reflect.DeepEqual(lib.Rewrites, []string{"moved"}) {
+		t.Errorf("Expected the 'moved' rule only, got %v instead", lib.Rewrites)
+	}
+	if lib.Original == nil || lib.Original.Url.String() != "http://old.com/foo" ||
+		lib.Original.Type != "" {
+		t.Errorf("Expected the dependency as requested, got %v instead", lib.Original)
+	}
+
+	// both make it into the lock file, which still reads back in
+	buf := &bytes.Buffer{}
+	if err := WriteLockFile(buf, "", nil, []*Library{lib}); err != nil {
+		t.Fatalf("Error writing lock file: %v", err)
+	}
+	for _, line := range []string{
+		`rewrites = ["moved"]`,
+		`[dependencies.requested]`,
+		`url = "http://old.com/foo"`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected '%s' in the lock file:\n%v", line, buf)
+		}
+	}
+	lockFile, err := ioutil.TempFile("", "grapnel-lock")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.Remove(lockFile.Name())
+	lockFile.Write(buf.Bytes())
+	lockFile.Close()
+	if deps, err := LoadGrapnelDepsfile(lockFile.Name()); err != nil {
+		t.Errorf("Error reading lock file: %v", err)
+	} else if len(deps) != 1 || deps[0].Url.String() != "http://new.com/foo" {
+		t.Errorf("Expected the resolved dependency back, got %v instead", deps)
+	}
+}
+
 func TestDeduplicateDeps(t *testing.T) {
 	log.SetGlobalLogLevel(log.DEBUG)
 
@@ -157,7 +240,7 @@ func TestLibResolveDeps(t *testing.T) {
 	// create a pre-resolved entry
 	dep := testDeps[0]
 	resolved[dep.Import] = &Library{
-		Version: &Version{1, 0, 0},
+		Version: NewVersion(1, 0, 0),
 	}
 	// test resolution
 	if deps, err := resolver.LibResolveDeps(resolved, testDeps); err != nil {
@@ -210,3 +293,93 @@ func TestResolveDependencies(t *testing.T) {
 			len(testDeps), len(libs))
 	}
 }
+
+// LibSource that serves the same source tree for everything
+type testTreeSCM struct {
+	testSCM
+	Dir string
+}
+
+func (self *testTreeSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+	lib.SourceDir = self.Dir
+	return lib, nil
+}
+
+func TestResolverSum(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package a"), 0644)
+
+	resolver := &Resolver{
+		LibSources: map[string]LibSource{
+			"test": &testTreeSCM{Dir: dir},
+		},
+	}
+	dep := &Dependency{
+		Import:      "dependency1",
+		VersionSpec: NewVersionSpec(OpEq, -1, -1, -1),
+		Type:        "test",
+	}
+
+	// the sum is filled in on resolve
+	lib, err := resolver.Resolve(dep)
+	if err != nil {
+		t.Fatalf("Error resolving dependency: %v", err)
+	}
+	if !strings.HasPrefix(lib.Sum, "sha256:") {
+		t.Fatalf("Expected a sum, got '%v' instead", lib.Sum)
+	}
+
+	// a matching sum is fine
+	dep.Sum = lib.Sum
+	if _, err := resolver.Resolve(dep); err != nil {
+		t.Errorf("Error resolving dependency with a matching sum: %v", err)
+	}
+
+	// anything else names the import
+	ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package b"), 0644)
+	if _, err := resolver.Resolve(dep); err == nil {
+		t.Errorf("Expected an error for a mismatched sum")
+	} else if !strings.Contains(err.Error(), "'dependency1'") {
+		t.Errorf("Expected the import in the error, got: %v", err)
+	}
+}
+
+func TestTaggedVersions(t *testing.T) {
+	versions := TaggedVersions(VersionSchemes["semver"], []string{"v1.2", "junk", "v1.10.0", "release-1.9.3", "1.2", "v1.11.0-rc.1"})
+	tags := []string{}
+	for _, version := range versions {
+		tags = append(tags, version.Tag)
+	}
+	if strings.Join(tags, " ") != "v1.11.0-rc.1 v1.10.0 release-1.9.3 v1.2 1.2" {
+		t.Errorf("Unexpected version order: %v", tags)
+	}
+
+	// the newest version that fits is picked, not the first one listed
+	for spec, expected := range map[string]string{
+		"1":             "v1.10.0",
+		"1.9":           "release-1.9.3",
+		">=1.9":         "v1.10.0",
+		"1.2":           "v1.2",
+		"2":             "",
+		"=1.2.*":        "v1.2",
+		">=1.11.0-rc.1": "v1.11.0-rc.1",
+	} {
+		versionSpec, err := ParseVersionSpec(spec)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		best := NewestVersion(versions, versionSpec)
+		if expected == "" && best != nil {
+			t.Errorf("Expected no match for '%v', got %v instead", spec, best.Tag)
+		} else if expected != "" && (best == nil || best.Tag != expected) {
+			t.Errorf("Expected %v for '%v', got %v instead", expected, spec, best)
+		}
+	}
+}
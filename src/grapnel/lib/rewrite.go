@@ -27,7 +27,11 @@ import (
 	"fmt"
 	toml "github.com/pelletier/go-toml"
 	log "grapnel/log"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
+	"strings"
 	"text/template"
 )
 
@@ -36,6 +40,7 @@ type ReplaceMap map[string]*template.Template
 type StringMap map[string]string
 
 type RewriteRule struct {
+	Name         string // recorded in the lock file for dependencies the rule changed
 	Matches      MatchMap
 	Replacements ReplaceMap
 }
@@ -53,16 +58,18 @@ func RewriteTemplate(tmpl string) (*template.Template, error) {
 	return template.New("").Funcs(replaceFuncs).Parse(tmpl)
 }
 
-func TypeResolverRule(matchField, matchExpr, typeValue string) *RewriteRule {
+func TypeResolverRule(name, matchField, matchExpr, typeValue string) *RewriteRule {
 	rule := NewRewriteRule()
+	rule.Name = name
 	rule.Matches["type"] = regexp.MustCompile(`^$`)
 	rule.Matches[matchField] = regexp.MustCompile(matchExpr)
 	rule.Replacements["type"] = template.Must(RewriteTemplate(typeValue))
 	return rule
 }
 
-func BuildRewriteRule(matches StringMap, replacements StringMap) *RewriteRule {
+func BuildRewriteRule(name string, matches StringMap, replacements StringMap) *RewriteRule {
 	rule := NewRewriteRule()
+	rule.Name = name
 	for key, value := range matches {
 		rule.Matches[key] = regexp.MustCompile(value)
 	}
@@ -72,6 +79,23 @@ func BuildRewriteRule(matches StringMap, replacements StringMap) *RewriteRule {
 	return rule
 }
 
+// Describes the rule in a canonical form, with fields in sorted order.
+func (self *RewriteRule) String() string {
+	parts := []string{}
+	for field, match := range self.Matches {
+		parts = append(parts, fmt.Sprintf("match %s %q", field, match.String()))
+	}
+	for field, tmpl := range self.Replacements {
+		text := ""
+		if tmpl.Tree != nil {
+			text = tmpl.Tree.Root.String()
+		}
+		parts = append(parts, fmt.Sprintf("replace %s %q", field, text))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "; ")
+}
+
 func (self *RewriteRule) AddMatch(field, expr string) error {
 	regex, err := regexp.Compile(expr)
 	if err != nil {
@@ -117,6 +141,15 @@ func (self *RewriteRule) Apply(dep *Dependency) error {
 		return err
 	}
 
+	// note the rule, unless it left the dependency as it was
+	if !reflect.DeepEqual(depValues, dep.Flatten()) {
+		name := self.Name
+		if name == "" {
+			name = self.String()
+		}
+		dep.Rewrites = append(dep.Rewrites, name)
+	}
+
 	log.Debug("Dependency rewritten: %t", dep)
 
 	// return new dependency
@@ -151,8 +184,15 @@ func LoadRewriteRules(filename string) (RewriteRuleArray, error) {
 	rules := RewriteRuleArray{}
 
 	if rewriteTree := tree.Get("rewrite"); rewriteTree != nil {
-		for _, ruleTree := range rewriteTree.([]*toml.TomlTree) {
+		for ii, ruleTree := range rewriteTree.([]*toml.TomlTree) {
 			rule := NewRewriteRule()
+			rule.Name = fmt.Sprintf("%s #%d", filepath.Base(filename), ii+1)
+			if name, ok := ruleTree.GetDefault("name", "").(string); !ok {
+				pos = ruleTree.GetPosition("name")
+				return errorf("Rule name must be a string value")
+			} else if name != "" {
+				rule.Name = name
+			}
 			matchTree, ok := ruleTree.Get("match").(*toml.TomlTree)
 			if !ok {
 				pos = ruleTree.GetPosition("")
@@ -210,6 +250,7 @@ var replaceFuncs = template.FuncMap{
 var BasicRewriteRules = RewriteRuleArray{
 	// generic rewrite for missing url
 	&RewriteRule{
+		Name: "default-url",
 		Matches: MatchMap{
 			"import": regexp.MustCompile(`.+`),
 			"url":    regexp.MustCompile(`^$`),
@@ -221,6 +262,7 @@ var BasicRewriteRules = RewriteRuleArray{
 
 	// generic rewrite for missing import
 	&RewriteRule{
+		Name: "default-import",
 		Matches: MatchMap{
 			"import": regexp.MustCompile(`^$`),
 			"url":    regexp.MustCompile(`.+`),
@@ -25,6 +25,9 @@ THE SOFTWARE.
 import (
 	log "grapnel/log"
 	url "grapnel/url"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"testing"
@@ -38,8 +41,9 @@ func TestRewrite(t *testing.T) {
 	rules = append(rules, GitRewriteRules...)
 
 	for _, test := range []struct {
-		Src *Dependency
-		Dst *Dependency
+		Src      *Dependency
+		Dst      *Dependency
+		Rewrites []string
 	}{
 		{
 			Src: &Dependency{
@@ -51,6 +55,7 @@ func TestRewrite(t *testing.T) {
 				Branch: "v3",
 				Type:   "git",
 			},
+			Rewrites: []string{"default-url", "gopkg.in-versioned"},
 		}, {
 			Src: &Dependency{
 				Import: "gopkg.in/foo.v1",
@@ -61,6 +66,7 @@ func TestRewrite(t *testing.T) {
 				Branch: "v1",
 				Type:   "git",
 			},
+			Rewrites: []string{"default-url", "gopkg.in"},
 		},
 	} {
 		if err := rules.Apply(test.Src); err != nil {
@@ -70,6 +76,9 @@ func TestRewrite(t *testing.T) {
 			t.Errorf("Error during replacement Src: %#v; Dst: %#v",
 				test.Src.Flatten(), test.Dst.Flatten())
 		}
+		if !reflect.DeepEqual(test.Src.Rewrites, test.Rewrites) {
+			t.Errorf("Expected rewrites %v, got %v instead", test.Rewrites, test.Src.Rewrites)
+		}
 	}
 }
 
@@ -98,10 +107,10 @@ func TestLoadRewriteRules(t *testing.T) {
 			},
 		},
 		// rewrite rules for misc git resolvers
-		TypeResolverRule("scheme", `git`, `git`),
-		TypeResolverRule("path", `.*\.git`, `git`),
-		TypeResolverRule("import", `github.com/.*`, `git`),
-		TypeResolverRule("host", `github.com`, `git`),
+		TypeResolverRule("git-scheme", "scheme", `git`, `git`),
+		TypeResolverRule("git-path", "path", `.*\.git`, `git`),
+		TypeResolverRule("git-import", "import", `github.com/.*`, `git`),
+		TypeResolverRule("git-host", "host", `github.com`, `git`),
 
 		// rewrite rules for gopkg.in
 		&RewriteRule{
@@ -173,3 +182,53 @@ func TestLoadEmptyRewriteRules(t *testing.T) {
 		return
 	}
 }
+
+func TestRewriteRuleString(t *testing.T) {
+	ruleA := BuildRewriteRule("example", StringMap{"type": `^$`, "host": `^example\.com$`},
+		StringMap{"host": `git.{{.host}}`, "type": `git`})
+	ruleB := NewRewriteRule()
+	ruleB.AddMatch("host", `^example\.com$`)
+	ruleB.AddMatch("type", `^$`)
+	ruleB.AddReplacement("type", `git`)
+	ruleB.AddReplacement("host", `git.{{.host}}`)
+
+	expected := `match host "^example\\.com$"; match type "^$"; replace host "git.{{.host}}"; replace type "git"`
+	if ruleA.String() != expected {
+		t.Errorf("Unexpected rule text: %v", ruleA)
+	}
+	if ruleB.String() != ruleA.String() {
+		t.Errorf("Expected the same text for the same rule: %v vs %v", ruleA, ruleB)
+	}
+}
+
+func TestLoadRewriteRuleNames(t *testing.T) {
+	rcFile, err := ioutil.TempFile("", "grapnelrc")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.Remove(rcFile.Name())
+	rcFile.WriteString(`
+[[rewrite]]
+  name = "mirror"
+  [rewrite.match]
+    host = "^example\\.com$"
+  [rewrite.replace]
+    host = "mirror.example.com"
+
+[[rewrite]]
+  [rewrite.match]
+    type = "^$"
+  [rewrite.replace]
+    type = "git"
+`)
+	rcFile.Close()
+
+	rules, err := LoadRewriteRules(rcFile.Name())
+	if err != nil {
+		t.Fatalf("Error loading rules: %v", err)
+	}
+	unnamed := filepath.Base(rcFile.Name()) + " #2"
+	if len(rules) != 2 || rules[0].Name != "mirror" || rules[1].Name != unnamed {
+		t.Errorf("Expected rules named 'mirror' and '%s', got %v instead", unnamed, rules)
+	}
+}
@@ -24,28 +24,37 @@ THE SOFTWARE.
 
 import (
 	"fmt"
-	"math"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
+// A version specification.  Oper and the version numbers describe the first
+// comparator in the expression; compound expressions, like '^1.4' or
+// '>=1.4, <2.0', keep the text as written.
 type VersionSpec struct {
-	Oper        int
-	Major       int
-	Minor       int
-	Subminor    int
-	minMajor    int
-	maxMajor    int
-	minMinor    int
-	maxMinor    int
-	minSubminor int
-	maxSubminor int
+	Oper       int
+	Major      int
+	Minor      int
+	Subminor   int
+	Prerelease string          // allows prerelease versions, from this one on
+	text       string          // the expression, for anything but a lone comparator
+	ranges     []*versionRange // a version has to fall within one of these
+}
+
+// A contiguous range of versions.  Nil bounds are open-ended.
+type versionRange struct {
+	min, max         *Version
+	minIncl, maxIncl bool
+	prerelease       bool // admits prerelease versions
 }
 
 type Version struct {
-	Major    int
-	Minor    int
-	Subminor int
+	Major      int
+	Minor      int
+	Subminor   int
+	Prerelease string // dot-separated identifiers, like 'rc.1'
+	Build      string // build metadata, which has no bearing on precedence
 }
 
 const (
@@ -57,6 +66,9 @@ const (
 )
 
 func (self *VersionSpec) String() string {
+	if self.text != "" {
+		return self.text
+	}
 	var op string
 	switch self.Oper {
 	case OpLt:
@@ -82,7 +94,11 @@ func (self *VersionSpec) String() string {
 	} else {
 		subminor = strconv.Itoa(self.Subminor)
 	}
-	return fmt.Sprintf("%s %v.%v.%v", op, self.Major, minor, subminor)
+	result := fmt.Sprintf("%s %v.%v.%v", op, self.Major, minor, subminor)
+	if self.Prerelease != "" {
+		result += "-" + self.Prerelease
+	}
+	return result
 }
 
 func (self *Version) String() string {
@@ -98,47 +114,215 @@ func (self *Version) String() string {
 	} else {
 		subminor = strconv.Itoa(self.Subminor)
 	}
-	return fmt.Sprintf("%v.%v.%v", self.Major, minor, subminor)
+	result := fmt.Sprintf("%v.%v.%v", self.Major, minor, subminor)
+	if self.Prerelease != "" {
+		result += "-" + self.Prerelease
+	}
+	if self.Build != "" {
+		result += "+" + self.Build
+	}
+	return result
+}
+
+// The lowest version covered by the numbers in a spec, with wildcards as zero.
+func floorVersion(major, minor, subminor int, prerelease string) *Version {
+	version := NewVersion(major, minor, subminor)
+	if version.Minor == -1 {
+		version.Minor = 0
+	}
+	if version.Subminor == -1 {
+		version.Subminor = 0
+	}
+	version.Prerelease = prerelease
+	return version
 }
 
-func getMinMax(oper int, value int) (int, int) {
-	var min, max int
-	if value == -1 {
-		min = -1
-		max = math.MaxInt32
+// The first release past everything covered by the numbers in a spec.
+func ceilVersion(major, minor, subminor int) *Version {
+	if minor == -1 {
+		return NewVersion(major+1, 0, 0)
+	} else if subminor == -1 {
+		return NewVersion(major, minor+1, 0)
+	}
+	return NewVersion(major, minor, subminor+1)
+}
+
+// The range of versions matched by a single comparator, like '>=1.2'.
+// Operators apply to everything the numbers cover, so '>1.2' starts at 1.3.0,
+// and '<=1.2' takes in all of 1.2.*.
+func comparatorRange(oper, major, minor, subminor int, prerelease string) *versionRange {
+	result := &versionRange{prerelease: prerelease != ""}
+	if major == -1 {
+		result.prerelease = true
+		return result // unversioned; anything goes, prereleases included
+	}
+	floor := floorVersion(major, minor, subminor, prerelease)
+	exact := subminor != -1
+	switch oper {
+	case OpEq:
+		result.min, result.minIncl = floor, true
+		if exact {
+			result.max, result.maxIncl = floor, true
+		} else {
+			result.max = ceilVersion(major, minor, subminor)
+		}
+	case OpGte:
+		result.min, result.minIncl = floor, true
+	case OpGt:
+		if exact {
+			result.min = floor
+		} else {
+			result.min, result.minIncl = ceilVersion(major, minor, subminor), true
+		}
+	case OpLt:
+		result.max = floor
+	case OpLte:
+		if exact {
+			result.max, result.maxIncl = floor, true
+		} else {
+			result.max = ceilVersion(major, minor, subminor)
+		}
+	}
+	return result
+}
+
+// '^1.4' allows anything up to the next change in the leftmost non-zero number.
+func caretRange(major, minor, subminor int, prerelease string) *versionRange {
+	result := &versionRange{
+		min:        floorVersion(major, minor, subminor, prerelease),
+		minIncl:    true,
+		prerelease: prerelease != "",
+	}
+	if major > 0 || minor == -1 {
+		result.max = NewVersion(major+1, 0, 0)
+	} else if minor > 0 || subminor == -1 {
+		result.max = NewVersion(0, minor+1, 0)
 	} else {
-		switch oper {
-		case OpLt:
-			min = -1
-			max = value - 1
-		case OpLte:
-			min = -1
-			max = value
-		case OpEq:
-			min = value
-			max = value
-		case OpGte:
-			min = value
-			max = math.MaxInt32
-		case OpGt:
-			min = value + 1
-			max = math.MaxInt32
-		}
-	}
-	return min, max
+		result.max = NewVersion(0, 0, subminor+1)
+	}
+	return result
+}
+
+// '~1.4.2' allows subminor updates, or minor updates if only the major is given.
+func tildeRange(major, minor, subminor int, prerelease string) *versionRange {
+	result := &versionRange{
+		min:        floorVersion(major, minor, subminor, prerelease),
+		minIncl:    true,
+		prerelease: prerelease != "",
+	}
+	if minor == -1 {
+		result.max = NewVersion(major+1, 0, 0)
+	} else {
+		result.max = NewVersion(major, minor+1, 0)
+	}
+	return result
+}
+
+func (self *versionRange) isEmpty() bool {
+	if self.min == nil || self.max == nil {
+		return false
+	}
+	result := self.min.Compare(self.max)
+	return result > 0 || result == 0 && !(self.minIncl && self.maxIncl)
+}
+
+// Returns the versions in both ranges, or nil if there are none.
+func (self *versionRange) intersect(other *versionRange, prerelease bool) *versionRange {
+	result := *self
+	result.prerelease = prerelease
+	if other.min != nil {
+		if result.min == nil {
+			result.min, result.minIncl = other.min, other.minIncl
+		} else if cmp := other.min.Compare(result.min); cmp > 0 || cmp == 0 && !other.minIncl {
+			result.min, result.minIncl = other.min, other.minIncl
+		}
+	}
+	if other.max != nil {
+		if result.max == nil {
+			result.max, result.maxIncl = other.max, other.maxIncl
+		} else if cmp := other.max.Compare(result.max); cmp < 0 || cmp == 0 && !other.maxIncl {
+			result.max, result.maxIncl = other.max, other.maxIncl
+		}
+	}
+	if result.isEmpty() {
+		return nil
+	}
+	return &result
+}
+
+// Returns true if every version in self is also in other.
+func (self *versionRange) within(other *versionRange) bool {
+	if self.prerelease && !other.prerelease {
+		return false
+	}
+	if other.min != nil {
+		if self.min == nil {
+			return false
+		} else if cmp := self.min.Compare(other.min); cmp < 0 || cmp == 0 && self.minIncl && !other.minIncl {
+			return false
+		}
+	}
+	if other.max != nil {
+		if self.max == nil {
+			return false
+		} else if cmp := self.max.Compare(other.max); cmp > 0 || cmp == 0 && self.maxIncl && !other.maxIncl {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *versionRange) contains(version *Version) bool {
+	if version.Prerelease != "" && !self.prerelease {
+		return false
+	}
+	if self.min != nil {
+		if cmp := version.Compare(self.min); cmp < 0 || cmp == 0 && !self.minIncl {
+			return false
+		}
+	}
+	if self.max != nil {
+		if cmp := version.Compare(self.max); cmp > 0 || cmp == 0 && !self.maxIncl {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *versionRange) String() string {
+	if self.min != nil && self.max != nil && self.minIncl && self.maxIncl &&
+		self.min.Compare(self.max) == 0 {
+		return "= " + self.min.String()
+	}
+	parts := []string{}
+	if self.min != nil {
+		if self.minIncl {
+			parts = append(parts, ">= "+self.min.String())
+		} else {
+			parts = append(parts, "> "+self.min.String())
+		}
+	}
+	if self.max != nil {
+		if self.maxIncl {
+			parts = append(parts, "<= "+self.max.String())
+		} else {
+			parts = append(parts, "< "+self.max.String())
+		}
+	}
+	if len(parts) == 0 {
+		return "*"
+	}
+	return strings.Join(parts, ", ")
 }
 
 func NewVersionSpec(oper, major, minor, subminor int) *VersionSpec {
-	version := &VersionSpec{
+	return &VersionSpec{
 		Oper:     oper,
 		Major:    major,
 		Minor:    minor,
 		Subminor: subminor,
+		ranges:   []*versionRange{comparatorRange(oper, major, minor, subminor, "")},
 	}
-	version.minMajor, version.maxMajor = getMinMax(oper, major)
-	version.minMinor, version.maxMinor = getMinMax(oper, minor)
-	version.minSubminor, version.maxSubminor = getMinMax(oper, subminor)
-	return version
 }
 
 func NewVersion(major, minor, subminor int) *Version {
@@ -157,19 +341,107 @@ var (
 	numTok           = `(\d+)`
 	dotTok           = `\.`
 	wildNumTok       = `(\d+|\*)`
+	identsTok        = `([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)`
+	prereleaseTok    = "(?:-" + identsTok + ")?"
+	buildTok         = "(?:\\+" + identsTok + ")?"
 	parseVersionSpec = regexp.MustCompile("^" +
 		sp + opsTok + "?" + sp + numTok +
 		"(" + sp + dotTok + sp + wildNumTok + ")?" +
 		"(" + sp + dotTok + sp + wildNumTok + ")?" +
+		prereleaseTok + buildTok +
 		sp + "$")
-	parseVersion = regexp.MustCompile("^" +
+	parseHyphenRange = regexp.MustCompile(`^(.+?)\s+-\s+(.+)$`)
+	parseVersion     = regexp.MustCompile("^" +
 		any + numTok +
 		"(" + sp + dotTok + sp + numTok + ")?" +
 		"(" + sp + dotTok + sp + numTok + ")?" +
+		prereleaseTok + buildTok +
 		sp + any + "$")
 )
 
+// Parses a version specification.  Besides a lone comparator, like '>=1.4',
+// this takes caret ('^1.4') and tilde ('~1.4.2') ranges, hyphen ranges
+// ('1.2 - 1.5'), comma-separated clauses that must all hold, and '||'
+// between alternatives.
 func ParseVersionSpec(src string) (*VersionSpec, error) {
+	if !strings.ContainsAny(src, ",|") {
+		return parseVersionClause(src)
+	}
+	var result *VersionSpec
+	ranges := []*versionRange{}
+	for _, alternative := range strings.Split(src, "||") {
+		var alternativeRange *versionRange
+		for _, clause := range strings.Split(alternative, ",") {
+			spec, err := parseVersionClause(clause)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot parse version spec: '%s'", src)
+			}
+			if result == nil {
+				result = spec
+			}
+			clauseRange := spec.ranges[0]
+			if alternativeRange == nil {
+				alternativeRange = clauseRange
+			} else if alternativeRange = alternativeRange.intersect(clauseRange,
+				alternativeRange.prerelease || clauseRange.prerelease); alternativeRange == nil {
+				return nil, fmt.Errorf("No version can satisfy version spec: '%s'", src)
+			}
+		}
+		ranges = append(ranges, alternativeRange)
+	}
+	result.text = strings.Join(strings.Fields(src), " ")
+	result.ranges = ranges
+	return result, nil
+}
+
+// Parses one clause of a version specification.
+func parseVersionClause(src string) (*VersionSpec, error) {
+	src = strings.TrimSpace(src)
+	if matches := parseHyphenRange.FindStringSubmatch(src); matches != nil {
+		lower, lowerErr := parseBareVersionSpec(matches[1])
+		upper, upperErr := parseBareVersionSpec(matches[2])
+		if lowerErr != nil || upperErr != nil {
+			return nil, fmt.Errorf("Cannot parse version spec: '%s'", src)
+		}
+		lower.ranges[0].max = upper.ranges[0].max
+		lower.ranges[0].maxIncl = upper.ranges[0].maxIncl
+		lower.ranges[0].prerelease = lower.ranges[0].prerelease || upper.ranges[0].prerelease
+		lower.Oper = OpGte
+		lower.text = matches[1] + " - " + matches[2]
+		if lower.ranges[0].isEmpty() {
+			return nil, fmt.Errorf("No version can satisfy version spec: '%s'", src)
+		}
+		return lower, nil
+	}
+
+	var rangeFn func(int, int, int, string) *versionRange
+	if strings.HasPrefix(src, "^") {
+		rangeFn = caretRange
+	} else if strings.HasPrefix(src, "~") {
+		rangeFn = tildeRange
+	} else {
+		return parseComparator(src)
+	}
+	spec, err := parseBareVersionSpec(src[1:])
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse version spec: '%s'", src)
+	}
+	spec.Oper = OpGte
+	spec.text = src[:1] + strings.TrimSpace(src[1:])
+	spec.ranges = []*versionRange{rangeFn(spec.Major, spec.Minor, spec.Subminor, spec.Prerelease)}
+	return spec, nil
+}
+
+// Parses a version specification that has no operator.
+func parseBareVersionSpec(src string) (*VersionSpec, error) {
+	if matches := parseVersionSpec.FindStringSubmatch(src); len(matches) == 0 || matches[1] != "" {
+		return nil, fmt.Errorf("Cannot parse version spec: '%s'", src)
+	}
+	return parseComparator(src)
+}
+
+// Parses a lone comparator, like '>=1.4'.
+func parseComparator(src string) (*VersionSpec, error) {
 	var oper, major, minor, subminor int
 	matches := parseVersionSpec.FindStringSubmatch(src)
 	if len(matches) == 0 {
@@ -195,12 +467,16 @@ func ParseVersionSpec(src string) (*VersionSpec, error) {
 	} else {
 		minor = -1
 	}
-	if matches[6] != "*" && matches[6] != "" {
+	// anything after a wildcard is a wildcard too
+	if matches[6] != "*" && matches[6] != "" && minor != -1 {
 		subminor, _ = strconv.Atoi(matches[6])
 	} else {
 		subminor = -1
 	}
-	return NewVersionSpec(oper, major, minor, subminor), nil
+	spec := NewVersionSpec(oper, major, minor, subminor)
+	spec.Prerelease = matches[7]
+	spec.ranges[0] = comparatorRange(oper, major, minor, subminor, spec.Prerelease)
+	return spec, nil
 }
 
 func ParseVersion(src string) (*Version, error) {
@@ -220,24 +496,254 @@ func ParseVersion(src string) (*Version, error) {
 	} else {
 		subminor = -1
 	}
-	return NewVersion(major, minor, subminor), nil
+	version := NewVersion(major, minor, subminor)
+	version.Prerelease = matches[6]
+	version.Build = matches[7]
+	return version, nil
+}
+
+// Compares the precedence of two versions, per Semantic Versioning 2.0.
+// Returns -1 if self comes before other, 1 if it comes after, and 0 if they
+// are equivalent.  Missing minor and subminor numbers sort before any
+// specific number, and build metadata is ignored.
+func (self *Version) Compare(other *Version) int {
+	for _, pair := range [][2]int{
+		{self.Major, other.Major},
+		{self.Minor, other.Minor},
+		{self.Subminor, other.Subminor},
+	} {
+		if pair[0] != pair[1] {
+			return compareInts(pair[0], pair[1])
+		}
+	}
+	return comparePrerelease(self.Prerelease, other.Prerelease)
+}
+
+// Returns true if self is an earlier version than other.
+func (self *Version) Less(other *Version) bool {
+	return self.Compare(other) < 0
+}
+
+func compareInts(left, right int) int {
+	if left < right {
+		return -1
+	} else if left > right {
+		return 1
+	}
+	return 0
+}
+
+// Compares prerelease identifiers.  A release comes after any of its
+// prereleases; otherwise, identifiers are compared in turn, numerically if
+// both are numbers, and numbers sort before anything else.
+func comparePrerelease(left, right string) int {
+	if left == right {
+		return 0
+	} else if left == "" {
+		return 1
+	} else if right == "" {
+		return -1
+	}
+	leftIds := strings.Split(left, ".")
+	rightIds := strings.Split(right, ".")
+	for ii := 0; ii < len(leftIds) && ii < len(rightIds); ii++ {
+		leftNum, leftErr := strconv.Atoi(leftIds[ii])
+		rightNum, rightErr := strconv.Atoi(rightIds[ii])
+		switch {
+		case leftErr == nil && rightErr == nil:
+			if leftNum != rightNum {
+				return compareInts(leftNum, rightNum)
+			}
+		case leftErr == nil:
+			return -1
+		case rightErr == nil:
+			return 1
+		case leftIds[ii] != rightIds[ii]:
+			return strings.Compare(leftIds[ii], rightIds[ii])
+		}
+	}
+	return compareInts(len(leftIds), len(rightIds))
 }
 
-// Returns true if 'other' is more specific and/or more recent than self.
+// Returns true if every version that satisfies self also satisfies other;
+// that is, self is at least as specific as other.
 func (self *VersionSpec) Outranks(other *VersionSpec) bool {
-	return self.minMajor >= other.minMajor && self.maxMajor <= other.maxMajor &&
-		self.minMinor >= other.minMinor && self.maxMinor <= other.maxMinor &&
-		self.minSubminor >= other.minSubminor && self.maxSubminor <= other.maxSubminor
+	for _, selfRange := range self.ranges {
+		within := false
+		for _, otherRange := range other.ranges {
+			if selfRange.within(otherRange) {
+				within = true
+				break
+			}
+		}
+		if !within {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a spec that is satisfied only by versions that satisfy both self
+// and other, or nil if no version can do that.
+func (self *VersionSpec) Intersect(other *VersionSpec) *VersionSpec {
+	ranges := []*versionRange{}
+	descriptions := []string{}
+	for _, selfRange := range self.ranges {
+		for _, otherRange := range other.ranges {
+			prerelease := selfRange.prerelease && otherRange.prerelease
+			if result := selfRange.intersect(otherRange, prerelease); result != nil {
+				ranges = append(ranges, result)
+				descriptions = append(descriptions, result.String())
+			}
+		}
+	}
+	if len(ranges) == 0 {
+		return nil
+	}
+	result := *self
+	result.text = strings.Join(descriptions, " || ")
+	result.ranges = ranges
+	return &result
 }
 
 // Compares the range of possible valid versions in the spec to a specific version
 // Returns true if 'version' satisfies the specification
+// Prereleases only satisfy a specification that cites a prerelease itself,
+// or one with no version at all.
+// Missing minor and subminor numbers in the version are taken as zero.
 func (self *VersionSpec) IsSatisfiedBy(version *Version) bool {
-	return self.minMajor <= version.Major && self.maxMajor >= version.Major &&
-		self.minMinor <= version.Minor && self.maxMinor >= version.Minor &&
-		self.minSubminor <= version.Subminor && self.maxSubminor >= version.Subminor
+	normal := *version
+	if normal.Major >= 0 {
+		if normal.Minor == -1 {
+			normal.Minor = 0
+		}
+		if normal.Subminor == -1 {
+			normal.Subminor = 0
+		}
+	}
+	for _, versionRange := range self.ranges {
+		if versionRange.contains(&normal) {
+			return true
+		}
+	}
+	return false
 }
 
 func (self *VersionSpec) IsUnversioned() bool {
 	return self.Major == -1
 }
+
+// Reads versions out of tags, and decides the order of candidates.  A
+// dependency picks one by name with 'scheme'; more can be added to
+// VersionSchemes.
+type VersionScheme interface {
+	ParseVersion(tag string) (*Version, error)
+	Compare(left, right *TaggedVersion) int // -1 if left comes before right
+}
+
+var VersionSchemes = map[string]VersionScheme{
+	"semver":  &semverScheme{},
+	"calver":  &calverScheme{},
+	"gopkgin": &gopkginScheme{},
+	"lexical": &lexicalScheme{},
+}
+
+// Looks up a version scheme by name.  No name means semantic versioning.
+func FindVersionScheme(name string) (VersionScheme, error) {
+	if name == "" {
+		name = "semver"
+	}
+	if scheme, ok := VersionSchemes[name]; ok {
+		return scheme, nil
+	}
+	return nil, fmt.Errorf("Unknown version scheme: '%s'", name)
+}
+
+// Semantic Versioning 2.0, read leniently: anything before the first number,
+// like 'v' or 'release-', is ignored.
+type semverScheme struct{}
+
+func (self *semverScheme) ParseVersion(tag string) (*Version, error) {
+	return ParseVersion(tag)
+}
+
+func (self *semverScheme) Compare(left, right *TaggedVersion) int {
+	return left.Version.Compare(right.Version)
+}
+
+// Calendar versions: a four-digit year, then an optional month and day, like
+// '2015.01.24', 'r2015-01-24' or '20150124'.  There are no prereleases, so
+// dashes are only separators.
+type calverScheme struct{}
+
+var parseCalver = []*regexp.Regexp{
+	regexp.MustCompile(`^\D*(\d{4})(?:[._-](\d{1,2})(?:[._-](\d{1,2}))?)?$`),
+	regexp.MustCompile(`^\D*(\d{4})(\d{2})(\d{2})$`),
+}
+
+func (self *calverScheme) ParseVersion(tag string) (*Version, error) {
+	for _, expr := range parseCalver {
+		if matches := expr.FindStringSubmatch(strings.TrimSpace(tag)); matches != nil {
+			return versionFromMatches(matches[1], matches[2], matches[3]), nil
+		}
+	}
+	return nil, fmt.Errorf("Cannot parse calendar version: '%s'", tag)
+}
+
+func (self *calverScheme) Compare(left, right *TaggedVersion) int {
+	return left.Version.Compare(right.Version)
+}
+
+// gopkg.in-style tags: a 'v' and one to three numbers, like 'v2' or 'v2.1.3'.
+// Anything else, including prereleases, is not a version.
+type gopkginScheme struct{}
+
+var parseGopkgin = regexp.MustCompile(`^v(\d+)(?:\.(\d+)(?:\.(\d+))?)?$`)
+
+func (self *gopkginScheme) ParseVersion(tag string) (*Version, error) {
+	matches := parseGopkgin.FindStringSubmatch(strings.TrimSpace(tag))
+	if matches == nil {
+		return nil, fmt.Errorf("Cannot parse gopkg.in version: '%s'", tag)
+	}
+	return versionFromMatches(matches[1], matches[2], matches[3]), nil
+}
+
+func (self *gopkginScheme) Compare(left, right *TaggedVersion) int {
+	return left.Version.Compare(right.Version)
+}
+
+// Tags ordered as plain text.  The first three numbers in a tag are still
+// read as its version, so that version expressions can match it.
+type lexicalScheme struct{}
+
+var parseNumbers = regexp.MustCompile(`\d+`)
+
+func (self *lexicalScheme) ParseVersion(tag string) (*Version, error) {
+	numbers := append(parseNumbers.FindAllString(tag, 3), "", "")
+	if numbers[0] == "" {
+		return nil, fmt.Errorf("Cannot find a number in tag: '%s'", tag)
+	}
+	return versionFromMatches(numbers[0], numbers[1], numbers[2]), nil
+}
+
+func (self *lexicalScheme) Compare(left, right *TaggedVersion) int {
+	return strings.Compare(left.Tag, right.Tag)
+}
+
+// Builds a version out of matched numbers; empty ones are left out.
+func versionFromMatches(major, minor, subminor string) *Version {
+	version := NewVersion(-1, -1, -1)
+	for _, pair := range []struct {
+		text  string
+		value *int
+	}{
+		{major, &version.Major},
+		{minor, &version.Minor},
+		{subminor, &version.Subminor},
+	} {
+		if pair.text != "" {
+			*pair.value, _ = strconv.Atoi(pair.text)
+		}
+	}
+	return version
+}
@@ -24,6 +24,7 @@ THE SOFTWARE.
 
 import (
 	log "grapnel/log"
+	"strings"
 	"testing"
 )
 
@@ -38,6 +39,7 @@ func TestParseVersionSpec(t *testing.T) {
 		" <= 1.*.* ":   NewVersionSpec(OpLte, 1, -1, -1),
 		" >= 100.0.* ": NewVersionSpec(OpGte, 100, 0, -1),
 		" 5 ":          NewVersionSpec(OpEq, 5, -1, -1),
+		">=1.2.3-rc.1": NewVersionSpec(OpGte, 1, 2, 3),
 	} {
 		version, err := ParseVersionSpec(k)
 		if err != nil {
@@ -57,9 +59,29 @@ func TestParseVersionSpec(t *testing.T) {
 		}
 	}
 
+	if spec, err := ParseVersionSpec(">1.2.3-rc.1"); err != nil {
+		t.Errorf("%v", err)
+	} else if spec.Prerelease != "rc.1" || spec.String() != "> 1.2.3-rc.1" {
+		t.Errorf("Bad prerelease for spec: %v", spec)
+	}
+
+	// compound expressions keep their text
+	for _, item := range []string{
+		"^1.4", "~1.4.2", "1.2 - 1.5", ">=1.4, <2.0", "^1.2 || ^2.0", ">=1.0, <1.5 || >=2.0",
+	} {
+		if spec, err := ParseVersionSpec(item); err != nil {
+			t.Errorf("Error parsing version: '%v': %v", item, err)
+		} else if spec.String() != item {
+			t.Errorf("Expected '%v' as the spec text; got '%v'", item, spec.String())
+		} else if spec.IsUnversioned() {
+			t.Errorf("Expected '%v' to be versioned", item)
+		}
+	}
+
 	// negative tests
 	for _, item := range []string{
-		"v1.0", "1.0xyz", "1.1.1.1",
+		"v1.0", "1.0xyz", "1.1.1.1", "1.0-", "1.0-rc..1",
+		"^>=1.0", "~", "1.0 - ", ">=1.0,", "|| 1.0", ">=2.0, <1.0", "1.5 - 1.2",
 	} {
 		if _, err := ParseVersionSpec(item); err == nil {
 			t.Errorf("Bad version parsed okay: %v", item)
@@ -96,6 +118,22 @@ func TestParseVersion(t *testing.T) {
 		}
 	}
 
+	// prereleases and build metadata
+	for k, v := range map[string][2]string{
+		"v1.2.3-rc.1":            {"rc.1", ""},
+		"1.2.3+build.5":          {"", "build.5"},
+		"1.0.0-beta.2+exp.sha.5": {"beta.2", "exp.sha.5"},
+		"1.0-final":              {"final", ""},
+		"1.0":                    {"", ""},
+	} {
+		version, err := ParseVersion(k)
+		if err != nil {
+			t.Errorf("Error parsing version: '%v': %v", k, err)
+		} else if version.Prerelease != v[0] || version.Build != v[1] {
+			t.Errorf("Bad prerelease or build for '%v': '%v' '%v'", k, version.Prerelease, version.Build)
+		}
+	}
+
 	// negative tests
 	for _, item := range []string{
 		"1.1.1.1",
@@ -124,6 +162,11 @@ func TestVersionSpecRank(t *testing.T) {
 		vsRankTest{">2", ">4", false, true},
 		vsRankTest{">2.0", ">4.0", false, true},
 		vsRankTest{">2.0.0", ">4.0.0", false, true},
+		vsRankTest{"^1.4", "~1.4.2", false, true},
+		vsRankTest{"^1.4", ">=1.4, <2.0", true, true},
+		vsRankTest{"1.2 - 1.5", ">=1.0, <2.0", true, false},
+		vsRankTest{"^1.2 || ^2.0", "^2.1", false, true},
+		vsRankTest{"^1.2", ">=1.0, <1.5 || >=2.0", false, false},
 	} {
 		var err error
 		var vsA, vsB *VersionSpec
@@ -163,6 +206,47 @@ func TestVersionSatisfaction(t *testing.T) {
 		vsSatisfyTest{"=1.0.*", "1.0", true},
 		vsSatisfyTest{"1.0", "1.0", true},
 		vsSatisfyTest{"1.0", "v1.0", true},
+
+		// prereleases only satisfy specs that ask for them
+		vsSatisfyTest{"1.2", "1.2.3-rc.1", false},
+		vsSatisfyTest{">=1.0", "1.2.3-rc.1", false},
+		vsSatisfyTest{"=1.2.3-rc.1", "1.2.3-rc.1", true},
+		vsSatisfyTest{"=1.2.3-rc.1", "1.2.3-rc.2", false},
+		vsSatisfyTest{">=1.2.3-rc.1", "1.2.3-rc.2", true},
+		vsSatisfyTest{">=1.2.3-rc.1", "1.2.3-beta", false},
+		vsSatisfyTest{">=1.2.3-rc.1", "1.2.3", true},
+		vsSatisfyTest{">1.2.3-rc.1", "1.2.3-rc.1", false},
+		vsSatisfyTest{">1.2.3-rc.1", "1.2.3", true},
+		vsSatisfyTest{"<1.2.3-rc.1", "1.2.3-alpha", true},
+		vsSatisfyTest{"1.2.3", "1.2.3+build.7", true},
+
+		// ranges compare whole versions
+		vsSatisfyTest{"<1.10", "1.9.3", true},
+		vsSatisfyTest{"<2.0", "1.9", true},
+		vsSatisfyTest{"<=1.2", "1.2.9", true},
+		vsSatisfyTest{">1.2", "1.2.9", false},
+		vsSatisfyTest{">1.2", "1.3", true},
+		vsSatisfyTest{"^1.4", "1.4.0", true},
+		vsSatisfyTest{"^1.4", "1.9.9", true},
+		vsSatisfyTest{"^1.4", "1.3.9", false},
+		vsSatisfyTest{"^1.4", "2.0.0", false},
+		vsSatisfyTest{"^0.4", "0.4.7", true},
+		vsSatisfyTest{"^0.4", "0.5.0", false},
+		vsSatisfyTest{"^0.0.3", "0.0.4", false},
+		vsSatisfyTest{"~1.4.2", "1.4.9", true},
+		vsSatisfyTest{"~1.4.2", "1.4.1", false},
+		vsSatisfyTest{"~1.4.2", "1.5.0", false},
+		vsSatisfyTest{"~1", "1.9", true},
+		vsSatisfyTest{"1.2 - 1.5", "1.5.9", true},
+		vsSatisfyTest{"1.2 - 1.5", "1.6", false},
+		vsSatisfyTest{"1.2 - 1.5.0", "1.5.1", false},
+		vsSatisfyTest{">=1.4, <2.0", "1.9.9", true},
+		vsSatisfyTest{">=1.4, <2.0", "2.0", false},
+		vsSatisfyTest{">=1.4, <2.0", "1.3", false},
+		vsSatisfyTest{"^1.2 || ^3.0", "3.1", true},
+		vsSatisfyTest{"^1.2 || ^3.0", "2.1", false},
+		vsSatisfyTest{"^2.0.0-rc.1", "2.0.0-rc.2", true},
+		vsSatisfyTest{"^2.0", "2.1.0-rc.1", false},
 	} {
 		var err error
 		var vs *VersionSpec
@@ -179,3 +263,145 @@ func TestVersionSatisfaction(t *testing.T) {
 		}
 	}
 }
+
+func TestVersionSpecIntersect(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	for _, item := range []struct {
+		A, B   string
+		Result string // empty if nothing satisfies both
+	}{
+		{">=1.4", "<2.0", ">= 1.4.0, < 2.0.0"},
+		{"^1.2", "~1.4", ">= 1.4.0, < 1.5.0"},
+		{"^1.2 || ^2.0", ">=1.5, <2.5", ">= 1.5.0, < 2.0.0 || >= 2.0.0, < 2.5.0"},
+		{"1.2.3", ">=1.0", "= 1.2.3"},
+		{"^1.2", "^2.0", ""},
+		{"<1.0", ">=1.0", ""},
+	} {
+		var err error
+		var vsA, vsB *VersionSpec
+		if vsA, err = ParseVersionSpec(item.A); err != nil {
+			t.Fatalf("Error parsing version spec: '%v': %v", item.A, err)
+		}
+		if vsB, err = ParseVersionSpec(item.B); err != nil {
+			t.Fatalf("Error parsing version spec: '%v': %v", item.B, err)
+		}
+		result := vsA.Intersect(vsB)
+		if item.Result == "" {
+			if result != nil {
+				t.Errorf("Expected no intersection of '%v' and '%v'; got '%v'", item.A, item.B, result)
+			}
+		} else if result == nil {
+			t.Errorf("Expected '%v' from '%v' and '%v'; got nothing", item.Result, item.A, item.B)
+		} else if result.String() != item.Result {
+			t.Errorf("Expected '%v' from '%v' and '%v'; got '%v'", item.Result, item.A, item.B, result)
+		}
+	}
+}
+
+func TestVersionSchemes(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	for _, item := range []struct {
+		Scheme string
+		Tags   []string
+		Order  string // tags that parse, newest first
+	}{
+		{"semver", []string{"v1.2", "v1.10.0", "junk"}, "v1.10.0 v1.2"},
+		{"calver", []string{"r2015.01.24", "2015-02-01", "20141231", "2015.1", "v1.2", "release.r60"},
+			"2015-02-01 r2015.01.24 2015.1 20141231"},
+		{"gopkgin", []string{"v2", "v2.1.3", "v2.1", "v3.0.0-rc.1", "2.2", "v1.9"}, "v2.1.3 v2.1 v2 v1.9"},
+		{"lexical", []string{"build-7", "build-10", "build-9", "nightly"}, "build-9 build-7 build-10"},
+	} {
+		scheme, err := FindVersionScheme(item.Scheme)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		tags := []string{}
+		for _, version := range TaggedVersions(scheme, item.Tags) {
+			tags = append(tags, version.Tag)
+		}
+		if strings.Join(tags, " ") != item.Order {
+			t.Errorf("Unexpected version order for %v: %v", item.Scheme, tags)
+		}
+	}
+
+	// calendar versions read as year, month and day
+	if scheme, err := FindVersionScheme("calver"); err != nil {
+		t.Errorf("%v", err)
+	} else if version, err := scheme.ParseVersion("r2015.01.24"); err != nil {
+		t.Errorf("%v", err)
+	} else if version.String() != "2015.1.24" {
+		t.Errorf("Bad calendar version: %v", version)
+	} else if spec, _ := ParseVersionSpec("2015.01.24"); !spec.IsSatisfiedBy(version) {
+		t.Errorf("Expected '%v' to satisfy '%v'", version, spec)
+	}
+
+	if _, err := FindVersionScheme("bogus"); err == nil {
+		t.Errorf("Expected an error for an unknown version scheme")
+	}
+	if scheme, err := FindVersionScheme(""); err != nil || scheme != VersionSchemes["semver"] {
+		t.Errorf("Expected semver as the default version scheme")
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	for _, item := range [][]string{
+		{"1", "2"},
+		{"1.0", "1.1"},
+		{"1", "1.0"},
+		{"1.2.3", "1.2.4"},
+		{"1.9.9", "2.0.0"},
+	} {
+		var err error
+		var verA, verB *Version
+		if verA, err = ParseVersion(item[0]); err != nil {
+			t.Errorf("Error parsing version: '%v': %v", item[0], err)
+		}
+		if verB, err = ParseVersion(item[1]); err != nil {
+			t.Errorf("Error parsing version: '%v': %v", item[1], err)
+		}
+		if !verA.Less(verB) {
+			t.Errorf("Expected '%v' to be less than '%v'", item[0], item[1])
+		}
+		if verB.Less(verA) {
+			t.Errorf("Expected '%v' not to be less than '%v'", item[1], item[0])
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	// in order of precedence, per the Semantic Versioning 2.0 spec
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0-rc.1", "1.1.0",
+	}
+	for ii := range ordered {
+		verA, err := ParseVersion(ordered[ii])
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		for jj := range ordered {
+			verB, err := ParseVersion(ordered[jj])
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			expected := compareInts(ii, jj)
+			if result := verA.Compare(verB); result != expected {
+				t.Errorf("Expected '%v' compared to '%v' to be %v, got %v instead",
+					ordered[ii], ordered[jj], expected, result)
+			}
+		}
+	}
+
+	// build metadata doesn't count
+	verA, _ := ParseVersion("1.0.0+build.1")
+	verB, _ := ParseVersion("1.0.0+build.2")
+	if verA.Compare(verB) != 0 {
+		t.Errorf("Expected build metadata to be ignored")
+	}
+}
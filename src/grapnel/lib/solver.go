@@ -0,0 +1,297 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	log "grapnel/log"
+	"sync"
+)
+
+// A memoized call to a LibSource.  The first caller does the work, and
+// everyone else waits on 'done' for the result.
+type solverEntry struct {
+	done     chan struct{}
+	lib      *Library
+	versions []*TaggedVersion
+	err      error
+}
+
+// Backtracking dependency solver.
+//
+// The solver selects one library per import, in queue order.  Each selection
+// must satisfy every queued dependency on that import, and is tried against
+// each candidate version the LibSource advertises.  If a later dependency
+// conflicts with a selection, the solver backs up to the most recent decision
+// and tries its next candidate instead.
+//
+// Calls to the LibSources are memoized, and run at most resolver.Jobs at a
+// time, since each may be a clone or download.  Once the solve fails, the
+// rest are cancelled, and so are the commands they have in progress.  Other
+// solves, and anything else running commands, are left alone.
+type solver struct {
+	resolver   *Resolver
+	lock       sync.Mutex
+	cache      map[string]*solverEntry
+	prefetched map[string]bool
+	pending    sync.WaitGroup
+	slots      chan struct{} // one per call that may run at once
+	cancelled  chan struct{} // closed once the solve has failed
+	cancelOnce sync.Once
+}
+
+var errSolveCancelled = fmt.Errorf("Cancelled after an earlier error")
+
+func newSolver(resolver *Resolver) *solver {
+	return &solver{
+		resolver:   resolver,
+		cache:      map[string]*solverEntry{},
+		prefetched: map[string]bool{},
+		slots:      make(chan struct{}, resolver.jobs()),
+		cancelled:  make(chan struct{}),
+	}
+}
+
+// runs fn in a free slot, unless the solve is cancelled first
+func (self *solver) work(entry *solverEntry, fn func(*solverEntry)) {
+	select {
+	case self.slots <- struct{}{}:
+	case <-self.cancelled:
+		entry.err = errSolveCancelled
+		return
+	}
+	defer func() { <-self.slots }()
+
+	select {
+	case <-self.cancelled:
+		entry.err = errSolveCancelled
+	default:
+		fn(entry)
+	}
+}
+
+// stops any calls still waiting or in progress
+func (self *solver) cancel() {
+	self.cancelOnce.Do(func() {
+		close(self.cancelled)
+	})
+}
+
+// runs fn once per key, and hands the result to every caller
+func (self *solver) memoize(key string, fn func(*solverEntry)) *solverEntry {
+	self.lock.Lock()
+	entry, ok := self.cache[key]
+	if !ok {
+		entry = &solverEntry{done: make(chan struct{})}
+		self.cache[key] = entry
+	}
+	self.lock.Unlock()
+
+	if ok {
+		<-entry.done
+	} else {
+		self.work(entry, fn)
+		close(entry.done)
+	}
+	return entry
+}
+
+// resolves a dependency pinned to a specific tag
+func (self *solver) fetch(base *Dependency, tag string) (*Library, error) {
+	key := "lib:" + base.Import + "@" + base.Branch + "@" + tag
+	entry := self.memoize(key, func(entry *solverEntry) {
+		dep := base.Copy()
+		dep.keepOriginal()
+		dep.Tag = tag
+		dep.cancel = self.cancelled
+		entry.lib, entry.err = self.resolver.Resolve(dep)
+	})
+	return entry.lib, entry.err
+}
+
+// lists the tagged versions available for a dependency
+func (self *solver) listVersions(base *Dependency) ([]*TaggedVersion, error) {
+	key := "versions:" + base.Import + "@" + base.Branch
+	entry := self.memoize(key, func(entry *solverEntry) {
+		dep := base.Copy()
+		dep.cancel = self.cancelled
+		entry.versions, entry.err = self.resolver.ListVersions(dep)
+	})
+	return entry.versions, entry.err
+}
+
+// checks that a dependency can be fetched at all
+func (self *solver) available(base *Dependency) error {
+	key := "available:" + base.Import + "@" + base.Branch
+	entry := self.memoize(key, func(entry *solverEntry) {
+		dep := base.Copy()
+		dep.cancel = self.cancelled
+		entry.err = self.resolver.Available(dep)
+	})
+	return entry.err
+}
+
+// Returns the dependency to resolve against for a group of dependencies on
+// the same import, and the tags to try for it, in order of preference.
+func (self *solver) candidates(group []*Dependency) (*Dependency, []*TaggedVersion, error) {
+	// an explicit tag trumps any search for versions
+	for _, dep := range group {
+		if dep.Tag != "" {
+			return dep, []*TaggedVersion{&TaggedVersion{Tag: dep.Tag}}, nil
+		}
+	}
+
+	base := group[0]
+	versioned := false
+	for _, dep := range group {
+		versioned = versioned || !dep.VersionSpec.IsUnversioned()
+	}
+	if !versioned {
+		return base, []*TaggedVersion{&TaggedVersion{}}, nil
+	}
+
+	versions, err := self.listVersions(base)
+	if err != nil {
+		return nil, nil, err
+	}
+	results := []*TaggedVersion{}
+	for _, version := range versions {
+		if satisfiesAll(group, version.Version) {
+			results = append(results, version)
+		}
+	}
+	SortVersions(results)
+	return base, results, nil
+}
+
+// starts resolving the best candidate for each import in the queue
+func (self *solver) prefetch(queue []*Dependency) {
+	for _, dep := range queue {
+		self.lock.Lock()
+		done := self.prefetched[dep.Import]
+		self.prefetched[dep.Import] = true
+		self.lock.Unlock()
+		if done {
+			continue
+		}
+
+		group, _ := splitImport(queue, dep.Import)
+		self.pending.Add(1)
+		go func(group []*Dependency) {
+			defer self.pending.Done()
+			if base, candidates, err := self.candidates(group); err == nil && len(candidates) > 0 {
+				self.fetch(base, candidates[0].Tag)
+			}
+		}(group)
+	}
+}
+
+func (self *solver) solve(selected map[string]*Library, libs []*Library, queue []*Dependency) ([]*Library, error) {
+	// drop anything that is satisfied by what has been selected so far
+	queue, err := self.resolver.LibResolveDeps(selected, queue)
+	if err != nil {
+		return nil, err
+	}
+	if len(queue) == 0 {
+		return libs, nil
+	}
+	self.prefetch(queue)
+
+	// decide on the first import in the queue
+	group, rest := splitImport(queue, queue[0].Import)
+	base, candidates, err := self.candidates(group)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflict error = &ConflictError{Import: base.Import, Deps: group}
+	for _, candidate := range candidates {
+		lib, err := self.fetch(base, candidate.Tag)
+		if err != nil {
+			return nil, err
+		}
+		if !satisfiesAll(group, lib.Version) {
+			continue
+		}
+		log.Debug("Selected library: %s %v", lib.Import, lib.Version)
+
+		// carry on with this selection, and its dependencies queued up
+		nextSelected := map[string]*Library{}
+		for key, value := range selected {
+			nextSelected[key] = value
+		}
+		nextSelected[lib.Import] = lib
+		for _, importPath := range lib.Provides {
+			nextSelected[importPath] = lib
+		}
+		nextLibs := append(append([]*Library{}, libs...), lib)
+		nextQueue := append(append([]*Dependency{}, rest...), lib.Dependencies...)
+
+		result, err := self.solve(nextSelected, nextLibs, nextQueue)
+		if err == nil {
+			return result, nil
+		} else if _, ok := err.(*ConflictError); !ok {
+			return nil, err
+		}
+		log.Info("Backtracking from %s %v: %v", lib.Import, lib.Version, err)
+		conflict = err
+	}
+	return nil, conflict
+}
+
+// Resolves a set of dependencies to a graph of libraries that satisfies all
+// of them, and all of their dependencies in turn.
+func (self *solver) Solve(deps []*Dependency) (*Graph, error) {
+	libs, err := self.solve(map[string]*Library{}, []*Library{}, deps)
+	if err != nil {
+		self.cancel()
+	}
+	self.pending.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return NewGraph(deps, libs), nil
+}
+
+// splits out the dependencies on importPath from the rest of the queue
+func splitImport(queue []*Dependency, importPath string) ([]*Dependency, []*Dependency) {
+	group := []*Dependency{}
+	rest := []*Dependency{}
+	for _, dep := range queue {
+		if dep.Import == importPath {
+			group = append(group, dep)
+		} else {
+			rest = append(rest, dep)
+		}
+	}
+	return group, rest
+}
+
+func satisfiesAll(deps []*Dependency, version *Version) bool {
+	for _, dep := range deps {
+		if !dep.VersionSpec.IsSatisfiedBy(version) {
+			return false
+		}
+	}
+	return true
+}
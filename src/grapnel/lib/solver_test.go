@@ -0,0 +1,411 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	log "grapnel/log"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// LibSource that serves releases out of a map of import -> tag -> dependencies
+type testRepoSCM struct {
+	Repos map[string][]testRelease
+}
+
+type testRelease struct {
+	Tag  string
+	Deps []string // pairs of import and version spec
+}
+
+func (self *testRepoSCM) Resolve(dep *Dependency) (*Library, error) {
+	if _, ok := self.Repos[dep.Import]; !ok {
+		return nil, fmt.Errorf("No such repository: '%s'", dep.Import)
+	}
+	lib := NewLibrary(dep)
+	for _, release := range self.Repos[dep.Import] {
+		// take the tag as given, or the first release that will do
+		ver, _ := ParseVersion(release.Tag)
+		if release.Tag != dep.Tag && (dep.Tag != "" || !dep.VersionSpec.IsSatisfiedBy(ver)) {
+			continue
+		}
+		lib.Tag = release.Tag
+		lib.Version = ver
+		for ii := 0; ii < len(release.Deps); ii += 2 {
+			libDep, err := NewDependency(release.Deps[ii], "", release.Deps[ii+1])
+			if err != nil {
+				return nil, err
+			}
+			libDep.Type = "test"
+			lib.Dependencies = append(lib.Dependencies, libDep)
+		}
+		break
+	}
+	return lib, nil
+}
+
+func (self *testRepoSCM) ListVersions(dep *Dependency) ([]*TaggedVersion, error) {
+	results := []*TaggedVersion{}
+	for _, release := range self.Repos[dep.Import] {
+		ver, err := ParseVersion(release.Tag)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &TaggedVersion{Tag: release.Tag, Version: ver})
+	}
+	return results, nil
+}
+
+func (self *testRepoSCM) Available(dep *Dependency) error {
+	if _, ok := self.Repos[dep.Import]; !ok {
+		return fmt.Errorf("No such repository: '%s'", dep.Import)
+	}
+	return nil
+}
+
+func (self *testRepoSCM) BranchHead(dep *Dependency) (string, error) {
+	return "", nil
+}
+
+func (self *testRepoSCM) ToDSD(*Library) string {
+	return ""
+}
+
+func newTestRepoResolver(repos map[string][]testRelease) *Resolver {
+	return &Resolver{
+		LibSources: map[string]LibSource{
+			"test": &testRepoSCM{Repos: repos},
+		},
+	}
+}
+
+func testDeps(t *testing.T, specs ...string) []*Dependency {
+	deps := []*Dependency{}
+	for ii := 0; ii < len(specs); ii += 2 {
+		dep, err := NewDependency(specs[ii], "", specs[ii+1])
+		if err != nil {
+			t.Fatalf("Error creating dependency: %v", err)
+		}
+		dep.Type = "test"
+		deps = append(deps, dep)
+	}
+	return deps
+}
+
+func checkSelections(t *testing.T, libs []*Library, expected map[string]string) {
+	if len(libs) != len(expected) {
+		t.Errorf("Expected %v libraries, got %v instead", len(expected), len(libs))
+	}
+	for _, lib := range libs {
+		if tag, ok := expected[lib.Import]; !ok {
+			t.Errorf("Unexpected library: %v", lib.Import)
+		} else if lib.Tag != tag {
+			t.Errorf("Expected %v at %v, got %v instead", lib.Import, tag, lib.Tag)
+		}
+	}
+}
+
+func TestSolveOverlappingRanges(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	resolver := newTestRepoResolver(map[string][]testRelease{
+		"liba":   {{"1.0", []string{"shared", ">=1"}}},
+		"libb":   {{"1.0", []string{"shared", "<2"}}},
+		"shared": {{"2.1", nil}, {"1.5", nil}, {"1.0", nil}},
+	})
+
+	libs, err := resolver.ResolveDependencies(testDeps(t, "liba", "1", "libb", "1"))
+	if err != nil {
+		t.Fatalf("Error resolving dependencies: %v", err)
+	}
+	checkSelections(t, libs, map[string]string{
+		"liba":   "1.0",
+		"libb":   "1.0",
+		"shared": "1.5",
+	})
+}
+
+func TestSolveBacktracking(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	// the newest 'liba' needs a 'shared' that conflicts with the root
+	resolver := newTestRepoResolver(map[string][]testRelease{
+		"liba": {
+			{"2.0", []string{"shared", "2"}},
+			{"1.0", []string{"shared", "1"}},
+		},
+		"shared": {{"2.0", nil}, {"1.0", nil}},
+	})
+
+	libs, err := resolver.ResolveDependencies(testDeps(t, "liba", ">=1", "shared", "1"))
+	if err != nil {
+		t.Fatalf("Error resolving dependencies: %v", err)
+	}
+	checkSelections(t, libs, map[string]string{
+		"liba":   "1.0",
+		"shared": "1.0",
+	})
+}
+
+func TestSolveUnversionedPrerelease(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	// 'liba' takes whatever 'shared' the root picks, prereleases included
+	resolver := newTestRepoResolver(map[string][]testRelease{
+		"liba":   {{"1.0", []string{"shared", ""}}},
+		"shared": {{"2.0.0-rc.1", nil}, {"1.0", nil}},
+	})
+
+	libs, err := resolver.ResolveDependencies(testDeps(t, "liba", "1", "shared", ">=2.0.0-rc.1"))
+	if err != nil {
+		t.Fatalf("Error resolving dependencies: %v", err)
+	}
+	checkSelections(t, libs, map[string]string{
+		"liba":   "1.0",
+		"shared": "2.0.0-rc.1",
+	})
+}
+
+func TestSolveRewriteLeavesRoot(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	resolver := newTestRepoResolver(map[string][]testRelease{
+		"liba": {{"1.0", nil}},
+	})
+	resolver.RewriteRules = RewriteRuleArray{
+		BuildRewriteRule("moved", StringMap{"host": `^old\.com$`}, StringMap{"host": `new.com`}),
+	}
+	dep, err := NewDependency("liba", "http://old.com/liba", "1")
+	if err != nil {
+		t.Fatalf("Error creating dependency: %v", err)
+	}
+	dep.Type = "test"
+
+	// rules change the libraries, not the dependencies they were asked for with
+	libs, err := resolver.ResolveDependencies([]*Dependency{dep})
+	if err != nil {
+		t.Fatalf("Error resolving dependencies: %v", err)
+	}
+	if dep.Url.String() != "http://old.com/liba" {
+		t.Errorf("Expected the root dependency to keep its url, got %v instead", dep.Url)
+	}
+	if len(libs) != 1 || libs[0].Url.String() != "http://new.com/liba" {
+		t.Fatalf("Expected a rewritten library, got %v instead", libs)
+	}
+	if libs[0].Original == nil || libs[0].Original.Url.String() != "http://old.com/liba" {
+		t.Errorf("Expected the dependency as requested, got %v instead", libs[0].Original)
+	}
+}
+
+func TestSolveConflict(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	resolver := newTestRepoResolver(map[string][]testRelease{
+		"liba":   {{"1.0", []string{"shared", "2"}}},
+		"shared": {{"2.0", nil}, {"1.0", nil}},
+	})
+
+	_, err := resolver.ResolveDependencies(testDeps(t, "liba", "1", "shared", "1"))
+	if _, ok := err.(*ConflictError); !ok {
+		t.Fatalf("Expected a conflict error, got %v instead", err)
+	}
+
+	// both sides of the conflict should be explained
+	for _, path := range []string{
+		"root -> liba (= 1.*.*, picked 1.0.*) -> shared (= 2.*.*)",
+		"root -> shared (= 1.*.*)",
+	} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("Expected '%v' in conflict report:\n%v", path, err)
+		}
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	resolver := newTestRepoResolver(map[string][]testRelease{
+		"liba": {{"1.0", []string{"gone", "1"}}},
+		"libb": {{"1.0", nil}},
+	})
+
+	// everything that's missing is reported at once; 'gone' is left to the solve
+	err := resolver.FindMissing(testDeps(t, "liba", "1", "lost", "1", "libb", "1", "never", "1"))
+	missing, ok := err.(*MissingError)
+	if !ok {
+		t.Fatalf("Expected a missing error, got %v instead", err)
+	}
+	imports := []string{}
+	for _, dep := range missing.Deps {
+		imports = append(imports, dep.Import)
+	}
+	if strings.Join(imports, " ") != "lost never" {
+		t.Errorf("Expected 'lost' and 'never' to be missing, got %v instead", imports)
+	}
+
+	if err := resolver.FindMissing(testDeps(t, "libb", "1")); err != nil {
+		t.Errorf("Expected nothing missing, got %v instead", err)
+	}
+}
+
+// LibSource that takes its time, and keeps track of how many calls overlap
+type testSlowSCM struct {
+	testRepoSCM
+	lock    sync.Mutex
+	active  int
+	highest int
+	started chan struct{} // closed once 'stuck' is underway
+}
+
+// counts a call in progress, until the returned func is called
+func (self *testSlowSCM) enter() func() {
+	self.lock.Lock()
+	self.active++
+	if self.active > self.highest {
+		self.highest = self.active
+	}
+	self.lock.Unlock()
+	return func() {
+		self.lock.Lock()
+		self.active--
+		self.lock.Unlock()
+	}
+}
+
+func (self *testSlowSCM) Available(dep *Dependency) error {
+	defer self.enter()()
+	time.Sleep(20 * time.Millisecond)
+	return self.testRepoSCM.Available(dep)
+}
+
+func (self *testSlowSCM) Resolve(dep *Dependency) (*Library, error) {
+	defer self.enter()()
+
+	switch dep.Import {
+	case "broken":
+		// fail once there is a command in progress to cancel
+		select {
+		case <-self.started:
+		case <-time.After(time.Second):
+		}
+		return nil, fmt.Errorf("No such repository: '%s'", dep.Import)
+	case "stuck":
+		close(self.started)
+		if err := dep.runContext("").Run("sleep", "30"); err != nil {
+			return nil, err
+		}
+	default:
+		time.Sleep(20 * time.Millisecond)
+	}
+	return self.testRepoSCM.Resolve(dep)
+}
+
+func TestSolveJobLimit(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	repos := map[string][]testRelease{}
+	specs := []string{}
+	for _, name := range []string{"liba", "libb", "libc", "libd", "libe", "libf"} {
+		repos[name] = []testRelease{{"1.0", nil}}
+		specs = append(specs, name, "")
+	}
+	source := &testSlowSCM{testRepoSCM: testRepoSCM{Repos: repos}}
+	resolver := &Resolver{
+		LibSources: map[string]LibSource{"test": source},
+		Jobs:       2,
+	}
+
+	if _, err := resolver.ResolveDependencies(testDeps(t, specs...)); err != nil {
+		t.Fatalf("Error resolving dependencies: %v", err)
+	}
+	if source.highest > 2 {
+		t.Errorf("Expected at most 2 calls at once, got %v instead", source.highest)
+	}
+}
+
+func TestFindMissingJobLimit(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	repos := map[string][]testRelease{}
+	specs := []string{}
+	for _, name := range []string{"liba", "libb", "libc", "libd", "libe", "libf"} {
+		repos[name] = []testRelease{{"1.0", nil}}
+		specs = append(specs, name, "")
+	}
+	source := &testSlowSCM{testRepoSCM: testRepoSCM{Repos: repos}}
+	resolver := &Resolver{
+		LibSources: map[string]LibSource{"test": source},
+		Jobs:       2,
+	}
+
+	if err := resolver.FindMissing(testDeps(t, specs...)); err != nil {
+		t.Fatalf("Expected nothing missing, got %v instead", err)
+	}
+	if source.highest > 2 {
+		t.Errorf("Expected at most 2 checks at once, got %v instead", source.highest)
+	}
+	if source.highest < 2 {
+		t.Errorf("Expected checks to run side by side, got %v at most", source.highest)
+	}
+}
+
+func TestSolveCancel(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not installed")
+	}
+
+	source := &testSlowSCM{
+		testRepoSCM: testRepoSCM{Repos: map[string][]testRelease{
+			"stuck": {{"1.0", nil}},
+		}},
+		started: make(chan struct{}),
+	}
+	resolver := &Resolver{
+		LibSources: map[string]LibSource{"test": source},
+	}
+
+	// a command run outside of the solve is left alone
+	other := NewRunContext("")
+	otherDone := make(chan error, 1)
+	go func() {
+		otherDone <- other.Run("sleep", "1")
+	}()
+
+	// the failure stops the command still in progress for 'stuck'
+	start := time.Now()
+	_, err := resolver.ResolveDependencies(testDeps(t, "broken", "", "stuck", ""))
+	if err == nil || !strings.Contains(err.Error(), "'broken'") {
+		t.Errorf("Expected an error for 'broken', got %v instead", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the resolve to be cancelled, but it took %v", elapsed)
+	}
+	if err := <-otherDone; err != nil {
+		t.Errorf("Expected the other command to finish, got %v instead", err)
+	}
+}
@@ -0,0 +1,204 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	log "grapnel/log"
+	"regexp"
+	"strings"
+)
+
+var SvnRewriteRules = RewriteRuleArray{
+	// rewrite rules for misc subversion resolvers
+	TypeResolverRule("svn-scheme", "scheme", `^svn(\+ssh)?$`, `svn`),
+	TypeResolverRule("svn-path", "path", `.*\.svn$`, `svn`),
+}
+
+// Subversion repositories, laid out with trunk/, branches/ and tags/ under
+// the repository url.  Tags are names of directories under tags/, and
+// anything that looks like a revision number is a revision of the branch.
+type SvnSCM struct {
+	Cache *Cache
+}
+
+var svnRevision = regexp.MustCompile(`^r?(\d+)$`)
+
+// a tag pinned to the revision it was last changed in, like 'v1.0@1234'
+var svnPinnedTag = regexp.MustCompile(`^(.+)@r?(\d+)$`)
+
+// splits a tag into its name and pinned revision, if it has one
+func svnSplitTag(tag string) (string, string) {
+	if match := svnPinnedTag.FindStringSubmatch(tag); match != nil {
+		return match[1], match[2]
+	}
+	return tag, ""
+}
+
+// returns the path to a branch within the repository; 'trunk' by default
+func svnBranchPath(branch string) string {
+	if branch == "" || branch == "trunk" {
+		return "trunk"
+	} else if strings.Contains(branch, "/") {
+		return branch
+	}
+	return "branches/" + branch
+}
+
+// reports an item of information about a repository path
+func svnInfo(cmd *RunContext, itemUrl string, item string) (string, error) {
+	if err := cmd.Run("svn", "info", "--show-item", item, itemUrl); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(cmd.CombinedOutput), nil
+}
+
+// lists the directories under tags/
+func svnTags(cmd *RunContext, repoUrl string) ([]string, error) {
+	if err := cmd.Run("svn", "list", repoUrl+"/tags"); err != nil {
+		return nil, fmt.Errorf("Failed to acquire tag list for dependency")
+	}
+	results := []string{}
+	for _, line := range strings.Fields(cmd.CombinedOutput) {
+		results = append(results, strings.TrimSuffix(line, "/"))
+	}
+	return results, nil
+}
+
+func (self *SvnSCM) Resolve(dep *Dependency) (*Library, error) {
+	lib := NewLibrary(dep)
+	if lib.Url == nil {
+		return nil, fmt.Errorf("No url for dependency: '%s'", lib.Import)
+	}
+
+	// fix the tag, and default branch
+	if lib.Branch == "" {
+		lib.Branch = "trunk"
+	}
+	if lib.Tag == "" {
+		lib.Tag = "HEAD"
+	}
+
+	log.Info("Fetching Subversion Dependency: '%s'", lib.Import)
+	repoUrl := strings.TrimSuffix(lib.Url.String(), "/")
+
+	// Pin the Tag to the last revision of the branch if we just have "HEAD"
+	if lib.Tag == "HEAD" {
+		if self.Cache.Offline {
+			return nil, &NotCachedError{Url: repoUrl}
+		}
+		branchUrl := repoUrl + "/" + svnBranchPath(lib.Branch)
+		rev, err := svnInfo(lib.runContext(""), branchUrl, "last-changed-revision")
+		if err != nil {
+			return nil, fmt.Errorf("Cannot find the head of branch: '%s'", branchUrl)
+		}
+		lib.Tag = rev
+	}
+
+	// a tag may be locked along with its revision; versions come from the name
+	var tagRev string
+	lib.Tag, tagRev = svnSplitTag(lib.Tag)
+	if _, err := selectVersion(lib, dep, func() ([]string, error) {
+		if self.Cache.Offline {
+			return nil, &NotCachedError{Url: repoUrl}
+		}
+		return svnTags(lib.runContext(""), repoUrl)
+	}); err != nil {
+		return nil, err
+	}
+
+	// export the revision or tag, unless it's already in the cache
+	var itemPath, rev string
+	if match := svnRevision.FindStringSubmatch(lib.Tag); match != nil {
+		itemPath = svnBranchPath(lib.Branch)
+		rev = match[1]
+	} else {
+		// pin the tag to the revision it was last changed in, so that the
+		// lock file doesn't follow a tag that is later changed
+		itemPath = "tags/" + lib.Tag
+		rev = tagRev
+		if rev == "" {
+			tagUrl := repoUrl + "/" + itemPath
+			if self.Cache.Offline {
+				return nil, &NotCachedError{Url: tagUrl}
+			}
+			var err error
+			if rev, err = svnInfo(lib.runContext(""), tagUrl, "last-changed-revision"); err != nil {
+				return nil, fmt.Errorf("Cannot find tag: '%s'", tagUrl)
+			}
+		}
+		lib.Tag = lib.Tag + "@" + rev
+	}
+	itemUrl := repoUrl + "/" + itemPath
+	var err error
+	lib.SourceDir, err = self.Cache.SourceTree(CacheKey("svn", itemUrl, rev), func(dir string) error {
+		if self.Cache.Offline {
+			return &NotCachedError{Url: itemUrl}
+		}
+		return lib.runContext(dir).Run("svn", "export", "--force", "-r", rev, itemUrl+"@"+rev, dir)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if lib.Version.Major >= 0 {
+		log.Info("Resolved: %s %v", lib.Import, lib.Version)
+	}
+	return lib, nil
+}
+
+func (self *SvnSCM) ListVersions(dep *Dependency) ([]*TaggedVersion, error) {
+	if dep.Url == nil {
+		return nil, fmt.Errorf("No url for dependency: '%s'", dep.Import)
+	}
+	repoUrl := strings.TrimSuffix(dep.Url.String(), "/")
+	if self.Cache.Offline {
+		return nil, &NotCachedError{Url: repoUrl}
+	}
+
+	log.Info("Fetching Subversion tags: '%s'", dep.Import)
+	tags, err := svnTags(dep.runContext(""), repoUrl)
+	if err != nil {
+		return nil, err
+	}
+	return dep.TaggedVersions(tags), nil
+}
+
+func (self *SvnSCM) BranchHead(dep *Dependency) (string, error) {
+	if dep.Url == nil {
+		return "", fmt.Errorf("No url for dependency: '%s'", dep.Import)
+	}
+	branchUrl := strings.TrimSuffix(dep.Url.String(), "/") + "/" + svnBranchPath(dep.Branch)
+	if self.Cache.Offline {
+		return "", &NotCachedError{Url: branchUrl}
+	}
+	rev, err := svnInfo(NewRunContext(""), branchUrl, "last-changed-revision")
+	if err != nil {
+		return "", fmt.Errorf("Cannot find the head of branch: '%s'", branchUrl)
+	}
+	return rev, nil
+}
+
+func (self *SvnSCM) ToDSD(*Library) string {
+	return ""
+}
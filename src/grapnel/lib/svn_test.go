@@ -0,0 +1,170 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	. "grapnel/testing"
+	url "grapnel/url"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestSvnRewrite(t *testing.T) {
+	rules := RewriteRuleArray{}
+	rules = append(rules, BasicRewriteRules...)
+	rules = append(rules, SvnRewriteRules...)
+
+	for _, test := range []struct {
+		Src *Dependency
+		Dst *Dependency
+	}{
+		{
+			Src: &Dependency{
+				Import: "example.com/foo/bar",
+				Url:    url.MustParse("svn://example.com/foo/bar"),
+			},
+			Dst: &Dependency{
+				Import: "example.com/foo/bar",
+				Url:    url.MustParse("svn://example.com/foo/bar"),
+				Type:   "svn",
+			},
+		}, {
+			Src: &Dependency{
+				Import: "example.com/foo/bar.svn",
+			},
+			Dst: &Dependency{
+				Import: "example.com/foo/bar.svn",
+				Url:    url.MustParse("http://example.com/foo/bar.svn"),
+				Type:   "svn",
+			},
+		},
+	} {
+		if err := rules.Apply(test.Src); err != nil {
+			t.Errorf("Error during replacement %v; Src: %v", err, test.Src.Flatten())
+		}
+		if !test.Src.Equal(test.Dst) {
+			t.Errorf("Error during replacement Src: %#v; Dst: %#v",
+				test.Src.Flatten(), test.Dst.Flatten())
+		}
+	}
+}
+
+func TestSvnBranchPath(t *testing.T) {
+	for branch, expected := range map[string]string{
+		"":             "trunk",
+		"trunk":        "trunk",
+		"stable":       "branches/stable",
+		"releases/1.x": "releases/1.x",
+	} {
+		if result := svnBranchPath(branch); result != expected {
+			t.Errorf("Expected %v for branch '%v', got %v instead", expected, branch, result)
+		}
+	}
+}
+
+func TestSvnSplitTag(t *testing.T) {
+	for tag, expected := range map[string][2]string{
+		"v1.0":       {"v1.0", ""},
+		"v1.0@1234":  {"v1.0", "1234"},
+		"v1.0@r1234": {"v1.0", "1234"},
+		"v1.0@head":  {"v1.0@head", ""},
+	} {
+		if name, rev := svnSplitTag(tag); name != expected[0] || rev != expected[1] {
+			t.Errorf("Expected %v for tag '%v', got %v, %v instead", expected, tag, name, rev)
+		}
+	}
+}
+
+func TestSvnSource(t *testing.T) {
+	for _, name := range []string{"svn", "svnadmin"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skip(name + " is not installed")
+		}
+	}
+	InitTestLogging()
+
+	basePath := BuildTestSvnRepo("svnrepo")
+	defer os.RemoveAll(basePath)
+	repoUrl := "file://" + filepath.Join(basePath, "svnrepo")
+
+	cacheRoot, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(cacheRoot)
+	libsrc := &SvnSCM{Cache: NewCache(cacheRoot)}
+
+	// versioned
+	dep, err := NewDependency("foo/bar/baz", repoUrl, "1.0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if lib.Tag != "v1.0@3" {
+		t.Errorf("Expected tag v1.0 pinned to revision 3, got %v instead", lib.Tag)
+	} else if !Exists(filepath.Join(lib.SourceDir, "README")) {
+		t.Errorf("Expected a source tree in %v", lib.SourceDir)
+	} else if Exists(filepath.Join(lib.SourceDir, "foo.txt")) {
+		t.Errorf("Expected the v1.0 source tree in %v", lib.SourceDir)
+	}
+
+	// a pinned tag reads back the same way
+	dep.Tag = "v1.0@3"
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if lib.Tag != "v1.0@3" || lib.Version.String() != "1.0.*" {
+		t.Errorf("Expected v1.0 at revision 3, got %v (%v) instead", lib.Tag, lib.Version)
+	}
+
+	// unversioned deps are pinned to the last revision of trunk
+	dep, err = NewDependency("foo/bar/baz", repoUrl, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if lib.Tag != "4" {
+		t.Errorf("Expected revision 4, got %v instead", lib.Tag)
+	} else if !Exists(filepath.Join(lib.SourceDir, "foo.txt")) {
+		t.Errorf("Expected the trunk source tree in %v", lib.SourceDir)
+	}
+
+	// a pinned revision
+	dep.Tag = "2"
+	if lib, err := libsrc.Resolve(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if Exists(filepath.Join(lib.SourceDir, "foo.txt")) {
+		t.Errorf("Expected the revision 2 source tree in %v", lib.SourceDir)
+	}
+
+	// test the tag listing
+	if versions, err := libsrc.ListVersions(dep); err != nil {
+		t.Errorf("%v", err)
+	} else if len(versions) != 2 {
+		t.Errorf("Expected 2 tagged versions, got %v instead", len(versions))
+	}
+}
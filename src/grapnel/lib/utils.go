@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"strings"
@@ -71,6 +72,20 @@ func CopyFileContents(src, dst string) (err error) {
 	return
 }
 
+// Copies a symlink as a link, replacing anything already at dst.
+func CopySymlink(src, dst string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, dst)
+}
+
 // http://stackoverflow.com/a/12527546
 // Exists reports whether the named file or directory exists.
 func Exists(name string) bool {
@@ -109,6 +124,7 @@ func AbsolutePath(path string) (string, error) {
 type RunContext struct {
 	WorkingDirectory string
 	CombinedOutput   string
+	Cancel           <-chan struct{} // closed to kill the command in progress
 }
 
 func NewRunContext(workingDirectory string) *RunContext {
@@ -118,11 +134,29 @@ func NewRunContext(workingDirectory string) *RunContext {
 }
 
 func (self *RunContext) Run(cmd string, args ...string) error {
+	select {
+	case <-self.Cancel:
+		return fmt.Errorf("Cancelled: %v %v", cmd, strings.Join(args, " "))
+	default:
+	}
+
 	cmdObj := exec.Command(cmd, args...)
 	cmdObj.Dir = self.WorkingDirectory
 	log.Debug("%v %v", cmd, args)
-	out, err := cmdObj.CombinedOutput()
-	self.CombinedOutput = string(out)
+	var out bytes.Buffer
+	cmdObj.Stdout = &out
+	cmdObj.Stderr = &out
+
+	err := cmdObj.Start()
+	if err == nil {
+		var killed bool
+		if killed, err = self.wait(cmdObj); killed {
+			self.CombinedOutput = out.String()
+			log.Debug("Killed: %v %v", cmd, args)
+			return fmt.Errorf("Cancelled: %v %v", cmd, strings.Join(args, " "))
+		}
+	}
+	self.CombinedOutput = out.String()
 	if err != nil {
 		if _, ok := err.(*exec.ExitError); ok {
 			log.Error("%s", out)
@@ -133,6 +167,22 @@ func (self *RunContext) Run(cmd string, args ...string) error {
 	return err
 }
 
+// waits for a command to finish, and kills it if the context is cancelled first
+func (self *RunContext) wait(cmdObj *exec.Cmd) (bool, error) {
+	result := make(chan error, 1)
+	go func() {
+		result <- cmdObj.Wait()
+	}()
+	select {
+	case err := <-result:
+		return false, err
+	case <-self.Cancel:
+		cmdObj.Process.Kill()
+		<-result
+		return true, nil
+	}
+}
+
 func (self *RunContext) Start(cmd string, args ...string) (*exec.Cmd, error) {
 	cmdObj := exec.Command(cmd, args...)
 	cmdObj.Dir = self.WorkingDirectory
@@ -162,6 +212,11 @@ func CopyFileTree(dest string, src string) error {
 					return err
 				}
 			}
+		} else if info.Mode()&os.ModeSymlink != 0 {
+			log.Debug("Linking: %s", destPath)
+			if err := CopySymlink(path, destPath); err != nil {
+				return fmt.Errorf("Could not copy link '%s' to '%s'", path, destPath)
+			}
 		} else {
 			log.Debug("Copying: %s", destPath)
 			if err := CopyFileContents(path, destPath); err != nil {
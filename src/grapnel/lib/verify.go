@@ -0,0 +1,265 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Kinds of drift between an installed tree and its lock file
+const (
+	DriftMissing    = "missing"    // locked library is not installed
+	DriftExtra      = "extra"      // installed, but not in the lock file
+	DriftModified   = "modified"   // installed files differ from the locked revision
+	DriftConstraint = "constraint" // lock file no longer satisfies the package file
+)
+
+// One problem found by VerifyInstall.
+type Drift struct {
+	Kind   string `json:"kind"`
+	Import string `json:"import,omitempty"`
+	Path   string `json:"path,omitempty"` // relative to the install root
+	Detail string `json:"detail,omitempty"`
+}
+
+func (self *Drift) String() string {
+	fields := []string{self.Kind}
+	for _, field := range []string{self.Import, self.Path, self.Detail} {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(fields, "\t")
+}
+
+// Checks the libraries installed under installRoot against the lock file,
+// and the lock file against the package file.  Nothing is modified.  If a
+// resolver is given, it is used to fetch the locked revision of a modified
+// library, so the files that differ can be named; that much may fill the
+// download cache.
+func VerifyInstall(roots []*Dependency, locked []*Library, installRoot string, resolver *Resolver) ([]*Drift, error) {
+	results := []*Drift{}
+	results = append(results, verifyConstraints(roots, locked)...)
+
+	imports := map[string]*Library{}
+	for _, lib := range locked {
+		imports[filepath.FromSlash(lib.Import)] = lib
+		libPath := filepath.Join(installRoot, lib.Import)
+		if !Exists(libPath) {
+			results = append(results, &Drift{Kind: DriftMissing, Import: lib.Import})
+			continue
+		}
+		drifts, err := verifyContents(lib, installRoot, nestedImports(lib, locked), resolver)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, drifts...)
+	}
+
+	drifts, err := verifyExtras(imports, installRoot)
+	if err != nil {
+		return nil, err
+	}
+	return append(results, drifts...), nil
+}
+
+// finds lock entries that no longer match what the package file asks for
+func verifyConstraints(roots []*Dependency, locked []*Library) []*Drift {
+	results := []*Drift{}
+	for _, dep := range roots {
+		var lib *Library
+		for _, item := range locked {
+			if item.Import == dep.Import {
+				lib = item
+				break
+			}
+		}
+
+		detail := ""
+		if lib == nil {
+			detail = "not in the lock file"
+		} else if !dep.VersionSpec.IsSatisfiedBy(lib.Version) {
+			detail = fmt.Sprintf("locked %s does not satisfy %s", lib.Selection(), dep.Constraint())
+		} else if dep.Tag != "" && dep.Tag != lib.Tag && !strings.HasPrefix(lib.Tag, dep.Tag+"@") {
+			// subversion tags are locked along with their revision, like 'v1.0@1234'
+			detail = fmt.Sprintf("locked tag %s, wanted %s", lib.Tag, dep.Tag)
+		} else if dep.Branch != "" && dep.Branch != lib.Branch {
+			detail = fmt.Sprintf("locked branch %s, wanted %s", lib.Branch, dep.Branch)
+		} else if dep.Url != nil && lib.Url != nil && !dep.Url.Equal(lib.Url) {
+			detail = fmt.Sprintf("locked url %s, wanted %s", lib.Url.String(), dep.Url.String())
+		}
+		if detail != "" {
+			results = append(results, &Drift{
+				Kind:   DriftConstraint,
+				Import: dep.Import,
+				Detail: detail,
+			})
+		}
+	}
+	return results
+}
+
+// finds the locked libraries installed inside another one, relative to it
+func nestedImports(lib *Library, locked []*Library) map[string]bool {
+	results := map[string]bool{}
+	for _, other := range locked {
+		if strings.HasPrefix(other.Import, lib.Import+"/") {
+			results[filepath.FromSlash(strings.TrimPrefix(other.Import, lib.Import+"/"))] = true
+		}
+	}
+	return results
+}
+
+// compares an installed library with the sum in the lock file, leaving out
+// the libraries nested inside it
+func verifyContents(lib *Library, installRoot string, nested map[string]bool, resolver *Resolver) ([]*Drift, error) {
+	if lib.Sum == "" {
+		return nil, nil // nothing to compare against
+	}
+	libPath := filepath.Join(installRoot, lib.Import)
+	sum, err := treeHash(libPath, nested)
+	if err != nil {
+		return nil, err
+	}
+	if sum == lib.Sum {
+		return nil, nil
+	}
+
+	// name the files that differ, if the locked revision can be had
+	if resolver != nil {
+		dep := lib.Dependency
+		if original, err := resolver.Resolve(&dep); err == nil {
+			files, err := diffTrees(original.SourceDir, libPath, nested)
+			if err != nil {
+				return nil, err
+			}
+			results := []*Drift{}
+			for _, file := range files {
+				results = append(results, &Drift{
+					Kind:   DriftModified,
+					Import: lib.Import,
+					Path:   filepath.ToSlash(filepath.Join(lib.Import, file)),
+				})
+			}
+			return results, nil
+		}
+	}
+	return []*Drift{&Drift{
+		Kind:   DriftModified,
+		Import: lib.Import,
+		Detail: fmt.Sprintf("expected %s, found %s", lib.Sum, sum),
+	}}, nil
+}
+
+// finds anything under installRoot that isn't part of a locked library
+func verifyExtras(imports map[string]*Library, installRoot string) ([]*Drift, error) {
+	results := []*Drift{}
+	if !Exists(installRoot) {
+		return results, nil
+	}
+	err := filepath.Walk(installRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, _ := filepath.Rel(installRoot, path)
+		if relativePath == "." {
+			return nil
+		}
+		if _, ok := imports[relativePath]; ok {
+			return filepath.SkipDir // checked by verifyContents
+		}
+
+		// keep looking under directories that lead to a library
+		if info.IsDir() {
+			for importPath := range imports {
+				if strings.HasPrefix(importPath, relativePath+string(filepath.Separator)) {
+					return nil
+				}
+			}
+		}
+		results = append(results, &Drift{
+			Kind: DriftExtra,
+			Path: filepath.ToSlash(relativePath),
+		})
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return results, err
+}
+
+// Returns the relative paths of files that were added, removed or changed
+// between two trees, in lexical order.
+func DiffTrees(before string, after string) ([]string, error) {
+	return diffTrees(before, after, nil)
+}
+
+// compares two trees, leaving out the skipped directories in both
+func diffTrees(before string, after string, skip map[string]bool) ([]string, error) {
+	beforeSums, err := fileSums(before, skip)
+	if err != nil {
+		return nil, err
+	}
+	afterSums, err := fileSums(after, skip)
+	if err != nil {
+		return nil, err
+	}
+	results := []string{}
+	for file, sum := range beforeSums {
+		if afterSums[file] != sum {
+			results = append(results, file)
+		}
+	}
+	for file := range afterSums {
+		if _, ok := beforeSums[file]; !ok {
+			results = append(results, file)
+		}
+	}
+	sort.Strings(results)
+	return results, nil
+}
+
+// maps each file in a tree to its content hash, leaving out the skipped directories
+func fileSums(dir string, skip map[string]bool) (map[string]string, error) {
+	results := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, _ := filepath.Rel(dir, path)
+		if info.IsDir() {
+			if skip[relativePath] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		results[relativePath], err = entryHash(path, info)
+		return err
+	})
+	return results, err
+}
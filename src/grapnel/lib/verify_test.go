@@ -0,0 +1,136 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	log "grapnel/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestTree(t *testing.T, root string, files map[string]string) {
+	for name, contents := range files {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+}
+
+func TestVerifyInstall(t *testing.T) {
+	log.SetGlobalLogLevel(log.DEBUG)
+
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(root)
+	sourceDir := filepath.Join(root, "source")
+	installRoot := filepath.Join(root, "install")
+
+	// every library is locked to the same source tree
+	writeTestTree(t, sourceDir, map[string]string{
+		"a.go":     "package a",
+		"sub/b.go": "package b",
+	})
+	sum, err := TreeHash(sourceDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	locked := []*Library{}
+	for _, importPath := range []string{"example.com/ok", "example.com/ok/inner",
+		"example.com/gone", "example.com/changed"} {
+		dep, _ := NewDependency(importPath, "", "1.0")
+		dep.Type = "test"
+		dep.Sum = sum
+		locked = append(locked, NewLockedLibrary(dep))
+	}
+
+	// a library installed inside another one doesn't count against it
+	for _, importPath := range []string{"example.com/ok", "example.com/ok/inner"} {
+		writeTestTree(t, filepath.Join(installRoot, importPath), map[string]string{
+			"a.go":     "package a",
+			"sub/b.go": "package b",
+		})
+	}
+	writeTestTree(t, filepath.Join(installRoot, "example.com/changed"), map[string]string{
+		"a.go":     "package a // edited",
+		"sub/b.go": "package b",
+		"new.go":   "package a",
+	})
+	writeTestTree(t, installRoot, map[string]string{
+		"example.com/stray/x.go": "package stray",
+		"notes.txt":              "",
+	})
+
+	roots := testDeps(t, "example.com/ok", "2", "example.com/changed", "1", "example.com/new", "1")
+	resolver := &Resolver{
+		LibSources: map[string]LibSource{
+			"test": &testTreeSCM{Dir: sourceDir},
+		},
+	}
+
+	check := func(drifts []*Drift, expected []string) {
+		results := []string{}
+		for _, drift := range drifts {
+			results = append(results, drift.Kind+" "+drift.Import+" "+drift.Path)
+		}
+		if strings.Join(results, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected:\n%v\nGot:\n%v", strings.Join(expected, "\n"),
+				strings.Join(results, "\n"))
+		}
+	}
+
+	// with a resolver, modified files are named
+	drifts, err := VerifyInstall(roots, locked, installRoot, resolver)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	check(drifts, []string{
+		"constraint example.com/ok ",
+		"constraint example.com/new ",
+		"missing example.com/gone ",
+		"modified example.com/changed example.com/changed/a.go",
+		"modified example.com/changed example.com/changed/new.go",
+		"extra  example.com/stray",
+		"extra  notes.txt",
+	})
+
+	// without one, the library is reported as a whole
+	drifts, err = VerifyInstall(nil, locked, installRoot, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	check(drifts, []string{
+		"missing example.com/gone ",
+		"modified example.com/changed ",
+		"extra  example.com/stray",
+		"extra  notes.txt",
+	})
+}
@@ -0,0 +1,69 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	log "grapnel/log"
+)
+
+// Picks the version of a library out of a repository, for the LibSources
+// that work from tags.  An unversioned library keeps whatever its Tag
+// already is, an explicit tag in dep must satisfy the version
+// specification, and otherwise the newest tag that does is picked from
+// those listTags returns.  Sets lib.Version, and lib.Tag if one is picked,
+// and reports whether it was.
+func selectVersion(lib *Library, dep *Dependency, listTags func() ([]string, error)) (bool, error) {
+	if lib.VersionSpec.IsUnversioned() {
+		// no semantic version information to go on
+		lib.Version = NewVersion(-1, -1, -1)
+		log.Warn("Resolved: %v (unversioned)", lib.Import)
+		return false, nil
+	} else if dep.Tag != "" {
+		// use the version cited by an explicit tag
+		ver, err := dep.ParseTag(lib.Tag)
+		if err != nil {
+			return false, fmt.Errorf("Cannot parse a version from tag: '%s'", lib.Tag)
+		} else if !dep.VersionSpec.IsSatisfiedBy(ver) {
+			return false, fmt.Errorf("Tag '%s' does not satisfy version specification: %v",
+				lib.Tag, lib.VersionSpec)
+		}
+		lib.Version = ver
+		return false, nil
+	}
+
+	// find the newest version match
+	tags, err := listTags()
+	if err != nil {
+		return false, err
+	}
+	best := NewestVersion(dep.TaggedVersions(tags), dep.VersionSpec)
+
+	// fail if the tag cannot be determined.
+	if best == nil {
+		return false, fmt.Errorf("Cannot find a tag for dependency version specification: %v.", lib.VersionSpec)
+	}
+	lib.Tag = best.Tag
+	lib.Version = best.Version
+	return true, nil
+}
@@ -0,0 +1,85 @@
+package lib
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	. "grapnel/testing"
+	"testing"
+)
+
+func TestSelectVersion(t *testing.T) {
+	InitTestLogging()
+	tags := []string{"v0.9", "v1.0", "v1.2", "v2.0", "foo"}
+
+	for ii, test := range []struct {
+		Version string
+		Tag     string
+		Picked  bool
+		Result  string // tag, then version; empty if it fails
+	}{
+		{"", "abc123", false, "abc123 -1.*.*"},
+		{"1.*", "", true, "v1.2 1.2.*"},
+		{">=1.0, <2.0", "", true, "v1.2 1.2.*"},
+		{"3.0", "", false, ""},
+		{"1.*", "v1.0", false, "v1.0 1.0.*"},
+		{"2.*", "v1.0", false, ""},
+		{"1.*", "foo", false, ""},
+	} {
+		dep, err := NewDependency("foo/bar", "", test.Version)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		dep.Tag = test.Tag
+		lib := NewLibrary(dep)
+		listed := false
+		picked, err := selectVersion(lib, dep, func() ([]string, error) {
+			listed = true
+			return tags, nil
+		})
+		if test.Result == "" {
+			if err == nil {
+				t.Errorf("Test %d: expected an error, got %v %v", ii, lib.Tag, lib.Version)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("Test %d: %v", ii, err)
+			continue
+		}
+		if result := lib.Tag + " " + lib.Version.String(); result != test.Result {
+			t.Errorf("Test %d: expected %v, got %v instead", ii, test.Result, result)
+		}
+		if picked != test.Picked || listed != test.Picked {
+			t.Errorf("Test %d: expected picked and listed to be %v, got %v and %v",
+				ii, test.Picked, picked, listed)
+		}
+	}
+
+	// errors listing the tags come back as they are
+	dep, _ := NewDependency("foo/bar", "", "1.*")
+	if _, err := selectVersion(NewLibrary(dep), dep, func() ([]string, error) {
+		return nil, fmt.Errorf("no tags")
+	}); err == nil || err.Error() != "no tags" {
+		t.Errorf("Expected the listing error, got %v instead", err)
+	}
+}
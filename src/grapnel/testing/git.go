@@ -26,7 +26,6 @@ import (
 	"bufio"
 	"bytes"
 	log "grapnel/log"
-	util "grapnel/util"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -104,7 +103,7 @@ func BuildTestGitRepo(repoName string) string {
 		panic(err)
 	}
 
-	cmd := util.NewRunContext(repoPath)
+	cmd := newFixtureCommand(repoPath)
 	for _, data := range [][]string{
 		{"git", "init"},
 		{"git", "config", "user.email", "you@example.com"},
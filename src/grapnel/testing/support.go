@@ -24,9 +24,30 @@ THE SOFTWARE.
 
 import (
 	log "grapnel/log"
+	"os/exec"
 )
 
 func InitTestLogging() {
 	log.SetGlobalLogLevel(log.DEBUG)
 	log.SetFlags(0)
 }
+
+// Runs the commands that build test fixtures.  This can't be lib's
+// RunContext, since lib's tests import this package.
+type fixtureCommand struct {
+	dir string
+}
+
+func newFixtureCommand(dir string) *fixtureCommand {
+	return &fixtureCommand{dir: dir}
+}
+
+// runs a command in the fixture directory, and stops the test run if it fails
+func (self *fixtureCommand) MustRun(cmd string, args ...string) {
+	log.Debug("%v %v", cmd, args)
+	cmdObj := exec.Command(cmd, args...)
+	cmdObj.Dir = self.dir
+	if out, err := cmdObj.CombinedOutput(); err != nil {
+		log.Fatal("%v %v: %v\n%s", cmd, args, err, out)
+	}
+}
@@ -0,0 +1,60 @@
+package testing
+
+/*
+Copyright (c) 2014 Eric Anderton <eric.t.anderton@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+import (
+	"io/ioutil"
+	"path"
+)
+
+func BuildTestSvnRepo(repoName string) string {
+	var err error
+	var basePath string
+	if basePath, err = ioutil.TempDir("", ""); err != nil {
+		panic(err)
+	}
+	repoPath := path.Join(basePath, repoName)
+	repoUrl := "file://" + repoPath
+	workPath := path.Join(basePath, "work")
+
+	cmd := newFixtureCommand(basePath)
+	cmd.MustRun("svnadmin", "create", repoPath)
+	cmd.MustRun("svn", "mkdir", "-m", "layout", repoUrl+"/trunk", repoUrl+"/branches",
+		repoUrl+"/tags")
+	cmd.MustRun("svn", "checkout", repoUrl+"/trunk", workPath)
+
+	cmd = newFixtureCommand(workPath)
+	for _, data := range [][]string{
+		{"touch", "README"},
+		{"svn", "add", "README"},
+		{"svn", "commit", "-m", "first commit"},
+		{"svn", "copy", "-m", "tag v1.0", repoUrl + "/trunk", repoUrl + "/tags/v1.0"},
+		{"touch", "foo.txt"},
+		{"svn", "add", "foo.txt"},
+		{"svn", "commit", "-m", "second commit"},
+		{"svn", "copy", "-m", "tag v1.1", repoUrl + "/trunk", repoUrl + "/tags/v1.1"},
+	} {
+		cmd.MustRun(data[0], data[1:]...)
+	}
+	return basePath
+}